@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gojektech/heimdall/httpclient"
+)
+
+// caddyClient is a Client for the admin api of caddy. Reverse proxy handlers
+// tagged with an @id in the config of caddy are upstreams, and their upstreams
+// are targets. Unhealthy upstreams are removed from the handler and re-added
+// once healthy, as caddy does not support a weight of 0. The upstreams of a
+// handler are changed by reading and patching the whole list, which is only
+// done by one worker at a time per handler so that no change is lost.
+type caddyClient struct {
+	httpClient httpDoer
+	adminURL   string
+	removed    *removedTargets
+
+	mu       sync.Mutex
+	handlers map[string]*sync.Mutex
+}
+
+// newCaddyClient keeps the upstreams it removed in the state file at
+// statePath, caddy no longer lists them so they would be lost for good on a
+// restart of probed otherwise.
+func newCaddyClient(adminURL string, timeout time.Duration, statePath string) (*caddyClient, error) {
+	if timeout == 0 {
+		timeout = 1000 * time.Millisecond
+	}
+
+	if statePath == "" {
+		return nil, fmt.Errorf("a state file is needed to keep the upstreams removed from caddy across restarts")
+	}
+
+	removed, err := newRemovedTargets(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &caddyClient{
+		httpClient: httpclient.NewClient(httpclient.WithHTTPTimeout(timeout)),
+		adminURL:   strings.TrimSuffix(adminURL, "/"),
+		removed:    removed,
+		handlers:   make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (cc *caddyClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+
+	respBytes, err := cc.doRequest(http.MethodGet, "config/", nil)
+	if err != nil {
+		return upstreams, err
+	}
+
+	var config interface{}
+	err = json.Unmarshal(respBytes, &config)
+	if err != nil {
+		return upstreams, err
+	}
+
+	ids := caddyReverseProxyIDs(config, []string{})
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		upstreams = append(upstreams, upstream{ID: id, Name: id})
+	}
+
+	return upstreams, nil
+}
+
+// targetsFor lists the upstreams of a reverse proxy handler, with the ones
+// probed removed at a weight of 0.
+func (cc *caddyClient) targetsFor(handlerID string) ([]target, error) {
+	targets := []target{}
+
+	handler := cc.handlerLock(handlerID)
+	handler.Lock()
+	defer handler.Unlock()
+
+	configs, err := cc.upstreamConfigs(handlerID)
+	if err != nil {
+		return targets, err
+	}
+
+	for _, config := range configs {
+		dial, _ := config["dial"].(string)
+		targets = append(targets, target{URL: dial, Weight: healthyNodeWeight, UpstreamID: handlerID})
+	}
+
+	return cc.removed.appendTo(handlerID, targets), nil
+}
+
+// setTargetWeightFor removes the upstream at targetURL for a weight of 0 and
+// re-adds it otherwise, caddy does not support weights of upstreams.
+func (cc *caddyClient) setTargetWeightFor(handlerID, targetURL string, weight int) error {
+	if weight <= unhealthyNodeWeight {
+		return cc.removeTarget(handlerID, targetURL)
+	}
+
+	return cc.addTarget(handlerID, targetURL)
+}
+
+// removeTarget keeps the config of an upstream before removing it from
+// caddy, so that a crash in between does not lose it
+func (cc *caddyClient) removeTarget(handlerID, targetURL string) error {
+	handler := cc.handlerLock(handlerID)
+	handler.Lock()
+	defer handler.Unlock()
+
+	configs, err := cc.upstreamConfigs(handlerID)
+	if err != nil {
+		return err
+	}
+
+	var removed map[string]interface{}
+	remaining := []map[string]interface{}{}
+	for _, config := range configs {
+		if config["dial"] == targetURL {
+			removed = config
+			continue
+		}
+
+		remaining = append(remaining, config)
+	}
+
+	if removed == nil {
+		return fmt.Errorf("no upstream at %s in reverse proxy %s", targetURL, handlerID)
+	}
+
+	err = cc.removed.remove(handlerID, targetURL, removed)
+	if err != nil {
+		return fmt.Errorf("failed to store removed upstream: %s", err)
+	}
+
+	err = cc.setUpstreamConfigs(handlerID, remaining)
+	if err != nil {
+		cc.removed.forget(handlerID, targetURL)
+		return err
+	}
+
+	return nil
+}
+
+// addTarget re-adds an upstream with the config it was removed with, and
+// only forgets the config once caddy has it again
+func (cc *caddyClient) addTarget(handlerID, targetURL string) error {
+	handler := cc.handlerLock(handlerID)
+	handler.Lock()
+	defer handler.Unlock()
+
+	removed, ok := cc.removed.configOf(handlerID, targetURL)
+	config, isConfig := removed.(map[string]interface{})
+	if !ok || !isConfig {
+		config = map[string]interface{}{"dial": targetURL}
+	}
+
+	configs, err := cc.upstreamConfigs(handlerID)
+	if err != nil {
+		return err
+	}
+
+	configured := false
+	for _, c := range configs {
+		if c["dial"] == targetURL {
+			configured = true
+			break
+		}
+	}
+
+	if !configured {
+		err = cc.setUpstreamConfigs(handlerID, append(configs, config))
+		if err != nil {
+			return err
+		}
+	}
+
+	return cc.removed.forget(handlerID, targetURL)
+}
+
+// handlerLock is held while the upstreams of a handler are read and changed
+func (cc *caddyClient) handlerLock(handlerID string) *sync.Mutex {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	handler, ok := cc.handlers[handlerID]
+	if !ok {
+		handler = &sync.Mutex{}
+		cc.handlers[handlerID] = handler
+	}
+
+	return handler
+}
+
+func (cc *caddyClient) upstreamConfigs(handlerID string) ([]map[string]interface{}, error) {
+	respBytes, err := cc.doRequest(http.MethodGet, fmt.Sprintf("id/%s/upstreams", url.PathEscape(handlerID)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []map[string]interface{}{}
+	err = json.Unmarshal(respBytes, &configs)
+	if err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+func (cc *caddyClient) setUpstreamConfigs(handlerID string, configs []map[string]interface{}) error {
+	requestBody, err := json.Marshal(configs)
+	if err != nil {
+		return err
+	}
+
+	_, err = cc.doRequest(http.MethodPatch, fmt.Sprintf("id/%s/upstreams", url.PathEscape(handlerID)), requestBody)
+	return err
+}
+
+// caddyReverseProxyIDs walks the config of caddy for reverse proxy handlers
+// tagged with an @id
+func caddyReverseProxyIDs(config interface{}, ids []string) []string {
+	switch value := config.(type) {
+	case map[string]interface{}:
+		id, hasID := value["@id"].(string)
+		if hasID && value["handler"] == "reverse_proxy" {
+			ids = append(ids, id)
+		}
+
+		for _, child := range value {
+			ids = caddyReverseProxyIDs(child, ids)
+		}
+	case []interface{}:
+		for _, child := range value {
+			ids = caddyReverseProxyIDs(child, ids)
+		}
+	}
+
+	return ids
+}
+
+func (cc *caddyClient) doRequest(method, path string, body []byte) ([]byte, error) {
+	return doJSONRequest(cc.httpClient, method, fmt.Sprintf("%s/%s", cc.adminURL, path), body, nil)
+}
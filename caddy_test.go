@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCaddyAdminAPI fakes the admin api of caddy with a reverse proxy handler
+// tagged orders, its upstreams can be read and patched. Reads of upstreams
+// take readDelay, to let concurrent changes overlap.
+func newCaddyAdminAPI(t *testing.T, readDelay time.Duration) *httptest.Server {
+	var mu sync.Mutex
+	upstreams := []byte(`[{"dial": "10.0.0.1:80", "max_requests": 10}, {"dial": "10.0.0.2:80"}]`)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		read := upstreams
+		mu.Unlock()
+
+		if r.Method+" "+r.URL.Path == "GET /id/orders/upstreams" {
+			time.Sleep(readDelay)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /config/":
+			w.Write([]byte(`{"apps": {"http": {"servers": {"srv0": {"routes": [
+				{"handle": [{"handler": "subroute", "routes": [{"handle": [{"@id": "orders", "handler": "reverse_proxy", "upstreams": []}]}]}]},
+				{"handle": [{"handler": "reverse_proxy", "upstreams": []}, {"@id": "files", "handler": "file_server"}]}
+			]}}}}}`))
+		case "GET /id/orders/upstreams":
+			w.Write(read)
+		case "PATCH /id/orders/upstreams":
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			upstreams = body
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func newTestCaddyClient(t *testing.T, adminURL string) (*caddyClient, string, func()) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+
+	statePath := filepath.Join(dir, "removed.json")
+	cc, err := newCaddyClient(adminURL, 0, statePath)
+	require.NoError(t, err)
+
+	return cc, statePath, func() { os.RemoveAll(dir) }
+}
+
+func TestNewCaddyClientNeedsStateFile(t *testing.T) {
+	_, err := newCaddyClient("http://127.0.0.1:2019", 0, "")
+	assert.Error(t, err, "should not remove upstreams which would be lost on a restart")
+}
+
+func TestCaddyClientListsTaggedReverseProxies(t *testing.T) {
+	httpServer := newCaddyAdminAPI(t, 0)
+	defer httpServer.Close()
+
+	cc, _, cleanup := newTestCaddyClient(t, httpServer.URL)
+	defer cleanup()
+
+	upstreams, err := cc.upstreams()
+	require.NoError(t, err)
+	assert.Equal(t, []upstream{{ID: "orders", Name: "orders"}}, upstreams)
+}
+
+func TestCaddyClientRemovesAndReAddsUpstreams(t *testing.T) {
+	httpServer := newCaddyAdminAPI(t, 0)
+	defer httpServer.Close()
+
+	cc, _, cleanup := newTestCaddyClient(t, httpServer.URL)
+	defer cleanup()
+
+	targets, err := cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.1:80", Weight: 100, UpstreamID: "orders"},
+		{URL: "10.0.0.2:80", Weight: 100, UpstreamID: "orders"},
+	}, targets)
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:80", 0))
+
+	configs, err := cc.upstreamConfigs("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"dial": "10.0.0.2:80"}}, configs)
+
+	targets, err = cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.2:80", Weight: 100, UpstreamID: "orders"},
+		{URL: "10.0.0.1:80", Weight: 0, UpstreamID: "orders"},
+	}, targets, "should keep listing the removed upstream")
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:80", 100))
+
+	configs, err = cc.upstreamConfigs("orders")
+	require.NoError(t, err)
+
+	expected := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"dial": "10.0.0.2:80"}, {"dial": "10.0.0.1:80", "max_requests": 10}]`), &expected))
+	assert.Equal(t, expected, configs, "should re-add the upstream with its config")
+
+	assert.Error(t, cc.setTargetWeightFor("orders", "10.0.0.9:80", 0), "should not remove unknown upstreams")
+}
+
+func TestCaddyClientReAddsUpstreamsRemovedBeforeARestart(t *testing.T) {
+	httpServer := newCaddyAdminAPI(t, 0)
+	defer httpServer.Close()
+
+	cc, statePath, cleanup := newTestCaddyClient(t, httpServer.URL)
+	defer cleanup()
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:80", 0))
+
+	restarted, err := newCaddyClient(httpServer.URL, 0, statePath)
+	require.NoError(t, err)
+
+	targets, err := restarted.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.2:80", Weight: 100, UpstreamID: "orders"},
+		{URL: "10.0.0.1:80", Weight: 0, UpstreamID: "orders"},
+	}, targets, "should still check the upstream removed before the restart")
+
+	require.NoError(t, restarted.setTargetWeightFor("orders", "10.0.0.1:80", 100))
+
+	configs, err := restarted.upstreamConfigs("orders")
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, map[string]interface{}{"dial": "10.0.0.1:80", "max_requests": 10.0}, configs[1], "should re-add the upstream with its config")
+}
+
+func TestCaddyClientRemovesUpstreamsOfAHandlerOneAtATime(t *testing.T) {
+	httpServer := newCaddyAdminAPI(t, 50*time.Millisecond)
+	defer httpServer.Close()
+
+	cc, _, cleanup := newTestCaddyClient(t, httpServer.URL)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for _, targetURL := range []string{"10.0.0.1:80", "10.0.0.2:80"} {
+		wg.Add(1)
+		go func(targetURL string) {
+			defer wg.Done()
+			assert.NoError(t, cc.setTargetWeightFor("orders", targetURL, 0))
+		}(targetURL)
+	}
+	wg.Wait()
+
+	configs, err := cc.upstreamConfigs("orders")
+	require.NoError(t, err)
+	assert.Empty(t, configs, "should not have put back an upstream removed at the same time")
+
+	targets, err := cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []target{
+		{URL: "10.0.0.1:80", Weight: 0, UpstreamID: "orders"},
+		{URL: "10.0.0.2:80", Weight: 0, UpstreamID: "orders"},
+	}, targets)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// checkPolicy is how the targets of an upstream are checked
+type checkPolicy struct {
+	checker    Checker
+	interval   time.Duration
+	thresholds *thresholdTracker
+}
+
+// checkDefaults are the global health check flags, which apply to every
+// upstream not overridden in the config file
+type checkDefaults struct {
+	checkType string
+	check     checkConfig
+	interval  time.Duration
+	rise      int
+	fall      int
+}
+
+type checkRule struct {
+	matches func(name string) bool
+	policy  *checkPolicy
+}
+
+type kongPolicy struct {
+	active kongActiveHealthcheck
+	policy *checkPolicy
+}
+
+// checkPolicies resolves the check policy of an upstream by its name, the
+// first matching rule of the config file wins. When enabled, upstreams which
+// no rule matches are checked as configured by their kong healthchecks, unless
+// those are the defaults kong reports for every upstream.
+type checkPolicies struct {
+	defaults     *checkPolicy
+	rules        []checkRule
+	fromKong     bool
+	baseDefaults checkDefaults
+
+	mu           sync.Mutex
+	kongPolicies map[string]kongPolicy
+}
+
+func newCheckPolicies(cfg *probedConfig, defaults checkDefaults, fromKong bool) (*checkPolicies, error) {
+	defaultPolicy, err := newCheckPolicy(defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := &checkPolicies{
+		defaults:     defaultPolicy,
+		fromKong:     fromKong,
+		baseDefaults: defaults,
+		kongPolicies: make(map[string]kongPolicy),
+	}
+
+	for i, uc := range cfg.Upstreams {
+		matches, err := upstreamMatcher(uc)
+		if err != nil {
+			return nil, fmt.Errorf("upstream config %d: %s", i, err)
+		}
+
+		policy, err := newCheckPolicy(uc.overrides(defaults))
+		if err != nil {
+			return nil, fmt.Errorf("upstream config %d: %s", i, err)
+		}
+
+		policies.rules = append(policies.rules, checkRule{matches: matches, policy: policy})
+	}
+
+	return policies, nil
+}
+
+func newCheckPolicy(defaults checkDefaults) (*checkPolicy, error) {
+	checker, err := newChecker(defaults.checkType, defaults.check)
+	if err != nil {
+		return nil, err
+	}
+
+	return &checkPolicy{
+		checker:    checker,
+		interval:   defaults.interval,
+		thresholds: newThresholdTracker(defaults.rise, defaults.fall),
+	}, nil
+}
+
+func (cp *checkPolicies) forUpstream(u upstream) *checkPolicy {
+	for _, rule := range cp.rules {
+		if rule.matches(u.Name) {
+			return rule.policy
+		}
+	}
+
+	if cp.fromKong && u.Healthchecks != nil && u.Healthchecks.Active != nil && !u.Healthchecks.Active.isKongDefault() {
+		return cp.kongPolicyFor(u.ID, *u.Healthchecks.Active)
+	}
+
+	return cp.defaults
+}
+
+// kongPolicyFor builds the policy of an upstream from its kong healthchecks,
+// it is only rebuilt when they change so that thresholds count across ticks.
+func (cp *checkPolicies) kongPolicyFor(upstreamID string, active kongActiveHealthcheck) *checkPolicy {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cached, ok := cp.kongPolicies[upstreamID]
+	if ok && reflect.DeepEqual(cached.active, active) {
+		return cached.policy
+	}
+
+	policy, err := newCheckPolicy(active.overrides(cp.baseDefaults))
+	if err != nil {
+		log.Printf("failed to use kong healthchecks of upstream %s, using defaults: %s", upstreamID, err)
+		policy = cp.defaults
+	}
+
+	cp.kongPolicies[upstreamID] = kongPolicy{active: active, policy: policy}
+	return policy
+}
+
+// minInterval is the shortest interval at which any upstream is checked,
+// intervals configured in kong do not shorten it.
+func (cp *checkPolicies) minInterval() time.Duration {
+	interval := cp.defaults.interval
+	for _, rule := range cp.rules {
+		if rule.policy.interval < interval {
+			interval = rule.policy.interval
+		}
+	}
+
+	return interval
+}
+
+func upstreamMatcher(uc upstreamCheckConfig) (func(string) bool, error) {
+	if uc.Name != "" {
+		return func(name string) bool { return name == uc.Name }, nil
+	}
+
+	if uc.Glob != "" {
+		_, err := path.Match(uc.Glob, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %s", uc.Glob, err)
+		}
+
+		return func(name string) bool {
+			matched, _ := path.Match(uc.Glob, name)
+			return matched
+		}, nil
+	}
+
+	if uc.Regex != "" {
+		re, err := regexp.Compile(uc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %s", uc.Regex, err)
+		}
+
+		return re.MatchString, nil
+	}
+
+	return nil, fmt.Errorf("one of name, glob or regex is required")
+}
+
+func (uc upstreamCheckConfig) overrides(defaults checkDefaults) checkDefaults {
+	if uc.Type != "" {
+		defaults.checkType = uc.Type
+	}
+	if uc.Path != nil {
+		defaults.check.path = *uc.Path
+	}
+	if uc.Interval > 0 {
+		defaults.interval = time.Duration(uc.Interval)
+	}
+	if uc.Timeout > 0 {
+		defaults.check.timeout = time.Duration(uc.Timeout)
+	}
+	if uc.Rise > 0 {
+		defaults.rise = uc.Rise
+	}
+	if uc.Fall > 0 {
+		defaults.fall = uc.Fall
+	}
+
+	return defaults
+}
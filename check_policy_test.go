@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCheckDefaults() checkDefaults {
+	return checkDefaults{
+		checkType: "tcp",
+		check:     checkConfig{path: "/ping"},
+		interval:  2 * time.Second,
+		rise:      1,
+		fall:      1,
+	}
+}
+
+func TestCheckPoliciesFallBackToDefaults(t *testing.T) {
+	policies, err := newCheckPolicies(&probedConfig{}, testCheckDefaults(), false)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	policy := policies.forUpstream(upstream{ID: "1", Name: "orders"})
+	assert.Equal(t, policies.defaults, policy)
+	assert.Equal(t, tcpChecker{}, policy.checker)
+	assert.Equal(t, 2*time.Second, policy.interval)
+	assert.Equal(t, 2*time.Second, policies.minInterval())
+}
+
+func TestCheckPoliciesMatchUpstreamsByNameGlobAndRegex(t *testing.T) {
+	healthPath := "/health"
+	cfg := &probedConfig{Upstreams: []upstreamCheckConfig{
+		{Name: "orders", Type: "http", Path: &healthPath, Rise: 2, Fall: 3},
+		{Glob: "redis-*", Interval: duration(5 * time.Second), Timeout: duration(time.Second)},
+		{Regex: "^payments-(a|b)$", Type: "grpc", Interval: duration(500 * time.Millisecond)},
+		{Glob: "orders*", Type: "grpc"},
+	}}
+
+	policies, err := newCheckPolicies(cfg, testCheckDefaults(), false)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	orders := policies.forUpstream(upstream{Name: "orders"})
+	require.IsType(t, httpChecker{}, orders.checker)
+	assert.Equal(t, "/health", orders.checker.(httpChecker).path)
+	assert.Equal(t, 2*time.Second, orders.interval)
+	assert.Equal(t, 2, orders.thresholds.rise)
+	assert.Equal(t, 3, orders.thresholds.fall)
+
+	redis := policies.forUpstream(upstream{Name: "redis-cache"})
+	assert.Equal(t, tcpChecker{timeout: time.Second}, redis.checker)
+	assert.Equal(t, 5*time.Second, redis.interval)
+	assert.Equal(t, 1, redis.thresholds.fall)
+
+	payments := policies.forUpstream(upstream{Name: "payments-b"})
+	assert.IsType(t, grpcChecker{}, payments.checker)
+
+	assert.Equal(t, policies.defaults, policies.forUpstream(upstream{Name: "payments-c"}))
+	assert.Equal(t, orders, policies.forUpstream(upstream{Name: "orders"}), "should resolve the same policy on every tick")
+	assert.Equal(t, 500*time.Millisecond, policies.minInterval())
+}
+
+func TestCheckPoliciesFailure(t *testing.T) {
+	configs := map[string]upstreamCheckConfig{
+		"without matcher": {Type: "tcp"},
+		"invalid glob":    {Glob: "redis-["},
+		"invalid regex":   {Regex: "redis-("},
+		"unknown type":    {Name: "orders", Type: "udp"},
+	}
+
+	for name, uc := range configs {
+		_, err := newCheckPolicies(&probedConfig{Upstreams: []upstreamCheckConfig{uc}}, testCheckDefaults(), false)
+		assert.Error(t, err, "should have failed on %s", name)
+	}
+
+	defaults := testCheckDefaults()
+	defaults.checkType = "udp"
+
+	_, err := newCheckPolicies(&probedConfig{}, defaults, false)
+	assert.Error(t, err, "should have failed on unknown default type")
+}
+
+func TestCheckPoliciesFromKongHealthchecks(t *testing.T) {
+	upstreamBytes := []byte(`{
+		"id": "1",
+		"name": "orders",
+		"healthchecks": {
+			"active": {
+				"type": "https",
+				"timeout": 0.5,
+				"http_path": "/status",
+				"https_sni": "orders.internal",
+				"https_verify_certificate": false,
+				"healthy": {"interval": 5, "http_statuses": [200, 302], "successes": 2},
+				"unhealthy": {"interval": 1, "http_failures": 3, "tcp_failures": 4}
+			}
+		}
+	}`)
+
+	u := upstream{}
+	require.NoError(t, json.Unmarshal(upstreamBytes, &u))
+
+	policies, err := newCheckPolicies(&probedConfig{}, testCheckDefaults(), true)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	policy := policies.forUpstream(u)
+	require.IsType(t, httpChecker{}, policy.checker)
+
+	checker := policy.checker.(httpChecker)
+	assert.Equal(t, "https", checker.scheme)
+	assert.Equal(t, "/status", checker.path)
+	assert.Equal(t, statusRanges{{from: 200, to: 200}, {from: 302, to: 302}}, checker.expectation.statuses)
+	assert.Equal(t, 500*time.Millisecond, checker.client.(*http.Client).Timeout)
+
+	tlsConfig := checker.client.(*http.Client).Transport.(*http.Transport).TLSClientConfig
+	assert.Equal(t, "orders.internal", tlsConfig.ServerName)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+
+	assert.Equal(t, 5*time.Second, policy.interval)
+	assert.Equal(t, 2, policy.thresholds.rise)
+	assert.Equal(t, 3, policy.thresholds.fall)
+
+	assert.Equal(t, policy, policies.forUpstream(u), "should reuse the policy while kong healthchecks are unchanged")
+
+	u.Healthchecks.Active.Healthy.Successes = 4
+	changed := policies.forUpstream(u)
+	assert.NotEqual(t, policy, changed, "should rebuild the policy when kong healthchecks change")
+	assert.Equal(t, 4, changed.thresholds.rise)
+}
+
+func TestCheckPoliciesFromKongHealthchecksDefaults(t *testing.T) {
+	u := upstream{ID: "1", Name: "redis", Healthchecks: &kongHealthchecks{Active: &kongActiveHealthcheck{Type: "tcp"}}}
+	u.Healthchecks.Active.Unhealthy.HTTPFailures = 5
+	u.Healthchecks.Active.Unhealthy.TCPFailures = 2
+
+	policies, err := newCheckPolicies(&probedConfig{}, testCheckDefaults(), true)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	policy := policies.forUpstream(u)
+	assert.Equal(t, tcpChecker{}, policy.checker)
+	assert.Equal(t, 2*time.Second, policy.interval, "should fall back to the default interval when disabled in kong")
+	assert.Equal(t, 1, policy.thresholds.rise)
+	assert.Equal(t, 2, policy.thresholds.fall)
+
+	preKong1 := upstream{ID: "2", Name: "orders", Healthchecks: &kongHealthchecks{Active: &kongActiveHealthcheck{HTTPPath: "/health"}}}
+	assert.IsType(t, httpChecker{}, policies.forUpstream(preKong1).checker, "should use http checks when kong does not report a type")
+}
+
+func TestCheckPoliciesIgnoreDefaultKongHealthchecks(t *testing.T) {
+	verify := true
+	kongDefault := &kongActiveHealthcheck{Type: "http", Timeout: 1, HTTPPath: "/", HTTPSVerifyCertificate: &verify}
+	kongDefault.Healthy.HTTPStatuses = []int{200, 302}
+
+	policies, err := newCheckPolicies(&probedConfig{}, testCheckDefaults(), true)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	redis := upstream{ID: "1", Name: "redis", Healthchecks: &kongHealthchecks{Active: kongDefault}}
+	assert.Equal(t, policies.defaults, policies.forUpstream(redis), "should use the defaults when kong reports its default healthchecks")
+
+	preKong1 := upstream{ID: "2", Name: "orders", Healthchecks: &kongHealthchecks{Active: &kongActiveHealthcheck{HTTPPath: "/"}}}
+	assert.Equal(t, policies.defaults, policies.forUpstream(preKong1), "should use the defaults when kong before 1.0 reports its default healthchecks")
+
+	configured := *kongDefault
+	configured.Healthy.Interval = 5
+	orders := upstream{ID: "3", Name: "orders", Healthchecks: &kongHealthchecks{Active: &configured}}
+	assert.NotEqual(t, policies.defaults, policies.forUpstream(orders), "should use kong healthchecks once they are enabled in kong")
+}
+
+func TestCheckPoliciesPreferConfigFileOverKongHealthchecks(t *testing.T) {
+	cfg := &probedConfig{Upstreams: []upstreamCheckConfig{{Name: "orders", Type: "grpc"}}}
+	u := upstream{ID: "1", Name: "orders", Healthchecks: &kongHealthchecks{Active: &kongActiveHealthcheck{Type: "http"}}}
+	unsupported := upstream{ID: "2", Name: "payments", Healthchecks: &kongHealthchecks{Active: &kongActiveHealthcheck{Type: "grpcs"}}}
+
+	policies, err := newCheckPolicies(cfg, testCheckDefaults(), true)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	assert.IsType(t, grpcChecker{}, policies.forUpstream(u).checker)
+	assert.Equal(t, policies.defaults, policies.forUpstream(unsupported), "should fall back to defaults on unsupported kong check types")
+
+	policies, err = newCheckPolicies(&probedConfig{}, testCheckDefaults(), false)
+	require.NoError(t, err, "should not have failed to create check policies")
+
+	assert.Equal(t, policies.defaults, policies.forUpstream(u), "should ignore kong healthchecks unless enabled")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Checker is the interface to a type of health check, a target is healthy
+// when check returns no error
+type Checker interface {
+	check(t target) error
+}
+
+// checkConfig holds the settings checkers are built from, each type of check
+// uses the ones relevant to it
+type checkConfig struct {
+	path        string
+	timeout     time.Duration
+	http        httpExpectation
+	tls         tlsOptions
+	grpcService string
+	resolver    *net.Resolver
+}
+
+type checkerFactory func(cfg checkConfig) (Checker, error)
+
+var checkerFactories = map[string]checkerFactory{}
+
+// registerChecker makes a type of check available by name, it is meant to be
+// called from the init of the file implementing the check.
+func registerChecker(name string, factory checkerFactory) {
+	if _, ok := checkerFactories[name]; ok {
+		panic(fmt.Sprintf("checker %s is already registered", name))
+	}
+
+	checkerFactories[name] = factory
+}
+
+func newChecker(name string, cfg checkConfig) (Checker, error) {
+	factory, ok := checkerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown health check type %q, supported types are %s", name, strings.Join(checkerTypes(), ", "))
+	}
+
+	return factory(cfg)
+}
+
+func checkerTypes() []string {
+	types := make([]string, 0, len(checkerFactories))
+	for name := range checkerFactories {
+		types = append(types, name)
+	}
+
+	sort.Strings(types)
+	return types
+}
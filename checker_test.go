@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticChecker struct {
+	err error
+}
+
+func (sc staticChecker) check(target) error {
+	return sc.err
+}
+
+func TestRegisterCheckerAddsCheckType(t *testing.T) {
+	registerChecker("static", func(cfg checkConfig) (Checker, error) {
+		return staticChecker{err: errors.New(cfg.path)}, nil
+	})
+	defer delete(checkerFactories, "static")
+
+	checker, err := newChecker("static", checkConfig{path: "/down"})
+	require.NoError(t, err, "should not have failed to create registered checker")
+	assert.EqualError(t, checker.check(target{}), "/down")
+
+	assert.Contains(t, checkerTypes(), "static")
+}
+
+func TestRegisterCheckerPanicsOnDuplicateType(t *testing.T) {
+	assert.Panics(t, func() {
+		registerChecker("tcp", func(checkConfig) (Checker, error) { return staticChecker{}, nil })
+	})
+}
+
+func TestNewCheckerRejectsUnknownType(t *testing.T) {
+	_, err := newChecker("udp", checkConfig{})
+	require.Error(t, err, "should have failed to create unknown checker")
+	assert.Contains(t, err.Error(), "grpc, http, https, tcp")
+}
+
+func TestCheckerTypes(t *testing.T) {
+	assert.Equal(t, []string{"grpc", "http", "https", "tcp"}, checkerTypes())
+}
@@ -1,8 +1,219 @@
 package main
 
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
 // Client is the interface to the Loadbalancer(Kong)
 type Client interface {
 	upstreams() ([]upstream, error)
 	targetsFor(upstreamID string) ([]target, error)
 	setTargetWeightFor(upstreamID, targetID string, weight int) error
 }
+
+// targetIDs remembers the ids load balancers know targets by, like the names
+// of haproxy servers, as weights are set for the address of a target.
+type targetIDs struct {
+	mu  sync.Mutex
+	ids map[targetKey]interface{}
+}
+
+func newTargetIDs() *targetIDs {
+	return &targetIDs{ids: make(map[targetKey]interface{})}
+}
+
+func (ti *targetIDs) set(t target, id interface{}) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	ti.ids[keyFor(t)] = id
+}
+
+// lookup finds the id of the target at targetURL, listing the targets of the
+// upstream again with list when it is not known yet
+func (ti *targetIDs) lookup(upstreamID, targetURL string, list func(upstreamID string) ([]target, error)) (interface{}, error) {
+	key := targetKey{upstreamID: upstreamID, url: targetURL}
+
+	ti.mu.Lock()
+	id, ok := ti.ids[key]
+	ti.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	_, err := list(upstreamID)
+	if err != nil {
+		return nil, err
+	}
+
+	ti.mu.Lock()
+	id, ok = ti.ids[key]
+	ti.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no target at %s in upstream %s", targetURL, upstreamID)
+	}
+
+	return id, nil
+}
+
+// targetRemover is implemented by Clients of load balancers which do not
+// support a weight of 0. Unhealthy targets are removed from the load balancer
+// and re-added once they are healthy, instead of changing their weight. The
+// Client keeps listing removed targets with a weight of 0, so that they are
+// still checked.
+type targetRemover interface {
+	removeTarget(upstreamID, targetURL string) error
+	addTarget(upstreamID, targetURL string) error
+}
+
+// entryWriter is implemented by Clients of load balancers which keep a
+// history of entries for every target, like kong before 2.2. The id of the
+// entry which marked a target unhealthy tells the targets probed marked
+// unhealthy apart from targets an operator removed later on.
+type entryWriter interface {
+	markUnhealthyEntry(upstreamID, targetURL string) (string, error)
+}
+
+// verdictReporter is implemented by Clients of load balancers which do not
+// know whether a target is healthy until probed tells them, like consul ttl
+// checks which start out critical. Healthy targets which need a verdict are
+// reported with their current weight.
+type verdictReporter interface {
+	needsVerdict(upstreamID, targetURL string) bool
+}
+
+type storedRemovedTarget struct {
+	UpstreamID string      `json:"upstream_id"`
+	URL        string      `json:"target"`
+	Config     interface{} `json:"config"`
+}
+
+// removedTargets keeps the config of targets which have been removed from a
+// load balancer, to re-add them as they were. When backed by a state file the
+// configs survive restarts of probed, for load balancers which no longer list
+// removed targets.
+type removedTargets struct {
+	path string
+
+	mu      sync.Mutex
+	configs map[targetKey]interface{}
+}
+
+func newRemovedTargets(path string) (*removedTargets, error) {
+	rt := &removedTargets{
+		path:    path,
+		configs: make(map[targetKey]interface{}),
+	}
+
+	if path == "" {
+		return rt, nil
+	}
+
+	stored := []storedRemovedTarget{}
+	err := readStateFile(path, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, srt := range stored {
+		rt.configs[targetKey{upstreamID: srt.UpstreamID, url: srt.URL}] = srt.Config
+	}
+
+	return rt, nil
+}
+
+// remove keeps the config of a target which is about to be removed from the
+// load balancer, a target which can not be kept should not be removed.
+func (rt *removedTargets) remove(upstreamID, targetURL string, config interface{}) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	key := targetKey{upstreamID: upstreamID, url: targetURL}
+	rt.configs[key] = config
+
+	err := rt.persist()
+	if err != nil {
+		delete(rt.configs, key)
+		return err
+	}
+
+	return nil
+}
+
+// configOf returns the config of a removed target, reporting whether it was
+// removed
+func (rt *removedTargets) configOf(upstreamID, targetURL string) (interface{}, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	config, ok := rt.configs[targetKey{upstreamID: upstreamID, url: targetURL}]
+	return config, ok
+}
+
+// forget drops the config of a target once it has been re-added
+func (rt *removedTargets) forget(upstreamID, targetURL string) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	delete(rt.configs, targetKey{upstreamID: upstreamID, url: targetURL})
+	return rt.persist()
+}
+
+func (rt *removedTargets) isRemoved(upstreamID, targetURL string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	_, ok := rt.configs[targetKey{upstreamID: upstreamID, url: targetURL}]
+	return ok
+}
+
+// appendTo adds the removed targets of an upstream to targets with a weight of
+// 0, unless they are configured again
+func (rt *removedTargets) appendTo(upstreamID string, targets []target) []target {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	configured := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		configured[t.URL] = true
+	}
+
+	forgotten := false
+	for key := range rt.configs {
+		if key.upstreamID != upstreamID {
+			continue
+		}
+
+		if configured[key.url] {
+			delete(rt.configs, key)
+			forgotten = true
+			continue
+		}
+
+		targets = append(targets, target{URL: key.url, Weight: unhealthyNodeWeight, UpstreamID: upstreamID})
+	}
+
+	if forgotten {
+		err := rt.persist()
+		if err != nil {
+			log.Printf("failed to store removed targets of upstream %s: reason: %s", upstreamID, err)
+		}
+	}
+
+	return targets
+}
+
+func (rt *removedTargets) persist() error {
+	if rt.path == "" {
+		return nil
+	}
+
+	stored := make([]storedRemovedTarget, 0, len(rt.configs))
+	for key, config := range rt.configs {
+		stored = append(stored, storedRemovedTarget{UpstreamID: key.upstreamID, URL: key.url, Config: config})
+	}
+
+	return writeStateFile(rt.path, stored)
+}
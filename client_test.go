@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemovedTargetsAreForgotten(t *testing.T) {
+	removed, err := newRemovedTargets("")
+	require.NoError(t, err)
+	require.NoError(t, removed.remove("orders", "10.0.0.1:80", map[string]interface{}{"dial": "10.0.0.1:80"}))
+
+	assert.True(t, removed.isRemoved("orders", "10.0.0.1:80"))
+	assert.False(t, removed.isRemoved("search", "10.0.0.1:80"))
+
+	config, ok := removed.configOf("orders", "10.0.0.1:80")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"dial": "10.0.0.1:80"}, config)
+
+	require.NoError(t, removed.forget("orders", "10.0.0.1:80"))
+	_, ok = removed.configOf("orders", "10.0.0.1:80")
+	assert.False(t, ok, "should have forgotten the target")
+}
+
+func TestRemovedTargetsSurviveRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "removed.json")
+
+	removed, err := newRemovedTargets(path)
+	require.NoError(t, err)
+	require.NoError(t, removed.remove("orders", "10.0.0.1:80", map[string]interface{}{"dial": "10.0.0.1:80", "max_requests": 10.0}))
+	require.NoError(t, removed.remove("orders", "10.0.0.2:80", map[string]interface{}{"dial": "10.0.0.2:80"}))
+	require.NoError(t, removed.forget("orders", "10.0.0.2:80"))
+
+	restarted, err := newRemovedTargets(path)
+	require.NoError(t, err)
+
+	config, ok := restarted.configOf("orders", "10.0.0.1:80")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"dial": "10.0.0.1:80", "max_requests": 10.0}, config)
+	assert.False(t, restarted.isRemoved("orders", "10.0.0.2:80"))
+}
+
+func TestRemovedTargetsAreAppendedWithWeightZero(t *testing.T) {
+	removed, err := newRemovedTargets("")
+	require.NoError(t, err)
+	require.NoError(t, removed.remove("orders", "10.0.0.2:80", nil))
+	require.NoError(t, removed.remove("orders", "10.0.0.3:80", nil))
+	require.NoError(t, removed.remove("search", "10.0.0.4:80", nil))
+
+	targets := removed.appendTo("orders", []target{{URL: "10.0.0.1:80", Weight: 100, UpstreamID: "orders"}, {URL: "10.0.0.3:80", Weight: 100, UpstreamID: "orders"}})
+
+	assert.Equal(t, []target{
+		{URL: "10.0.0.1:80", Weight: 100, UpstreamID: "orders"},
+		{URL: "10.0.0.3:80", Weight: 100, UpstreamID: "orders"},
+		{URL: "10.0.0.2:80", Weight: 0, UpstreamID: "orders"},
+	}, targets)
+	assert.False(t, removed.isRemoved("orders", "10.0.0.3:80"), "should have forgotten the target configured again")
+}
+
+func TestTargetIDsListTargetsOfUnknownAddresses(t *testing.T) {
+	ids := newTargetIDs()
+	ids.set(target{URL: "10.0.0.1:80", UpstreamID: "orders"}, "orders-1")
+
+	listed := 0
+	list := func(upstreamID string) ([]target, error) {
+		listed++
+		ids.set(target{URL: "10.0.0.2:80", UpstreamID: upstreamID}, upstreamID+"-2")
+		return []target{}, nil
+	}
+
+	id, err := ids.lookup("orders", "10.0.0.1:80", list)
+	require.NoError(t, err)
+	assert.Equal(t, "orders-1", id)
+	assert.Equal(t, 0, listed, "should not list targets of known addresses")
+
+	id, err = ids.lookup("orders", "10.0.0.2:80", list)
+	require.NoError(t, err)
+	assert.Equal(t, "orders-2", id)
+	assert.Equal(t, 1, listed)
+
+	_, err = ids.lookup("orders", "10.0.0.3:80", list)
+	assert.Error(t, err, "should fail for addresses which are not listed")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// duration decodes a time.Duration from a string like "1500ms" or "2s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = duration(parsed)
+	return nil
+}
+
+// upstreamCheckConfig overrides the global health check flags for the
+// upstreams matching one of name, glob or regex
+type upstreamCheckConfig struct {
+	Name  string `json:"name"`
+	Glob  string `json:"glob"`
+	Regex string `json:"regex"`
+
+	Type     string   `json:"type"`
+	Path     *string  `json:"path"`
+	Interval duration `json:"interval"`
+	Timeout  duration `json:"timeout"`
+	Rise     int      `json:"rise"`
+	Fall     int      `json:"fall"`
+}
+
+type probedConfig struct {
+	Upstreams []upstreamCheckConfig `json:"upstreams"`
+}
+
+func loadConfig(path string) (*probedConfig, error) {
+	cfg := &probedConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	cfgBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(cfgBytes, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	cfgFile, err := ioutil.TempFile("", "probed")
+	require.NoError(t, err)
+
+	_, err = cfgFile.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, cfgFile.Close())
+
+	return cfgFile.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfgFile := writeTestConfig(t, `{
+		"upstreams": [
+			{"glob": "redis-*", "type": "tcp", "interval": "5s", "timeout": "500ms", "fall": 3},
+			{"name": "orders", "type": "http", "path": "/health", "rise": 2}
+		]
+	}`)
+	defer os.Remove(cfgFile)
+
+	cfg, err := loadConfig(cfgFile)
+	require.NoError(t, err, "should not have failed to load config")
+	require.Equal(t, 2, len(cfg.Upstreams))
+
+	redis := cfg.Upstreams[0]
+	assert.Equal(t, "redis-*", redis.Glob)
+	assert.Equal(t, "tcp", redis.Type)
+	assert.Nil(t, redis.Path)
+	assert.Equal(t, duration(5*time.Second), redis.Interval)
+	assert.Equal(t, duration(500*time.Millisecond), redis.Timeout)
+	assert.Equal(t, 3, redis.Fall)
+
+	orders := cfg.Upstreams[1]
+	assert.Equal(t, "orders", orders.Name)
+	require.NotNil(t, orders.Path)
+	assert.Equal(t, "/health", *orders.Path)
+	assert.Equal(t, 2, orders.Rise)
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	cfg, err := loadConfig("")
+	require.NoError(t, err, "should not have failed without config file")
+	assert.Empty(t, cfg.Upstreams)
+}
+
+func TestLoadConfigFailure(t *testing.T) {
+	_, err := loadConfig("missing.json")
+	assert.Error(t, err, "should have failed to load missing config")
+
+	cfgFile := writeTestConfig(t, `{"upstreams": [{"name": "orders", "interval": "5 seconds"}]}`)
+	defer os.Remove(cfgFile)
+
+	_, err = loadConfig(cfgFile)
+	assert.Error(t, err, "should have failed to parse interval")
+}
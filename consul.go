@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gojektech/heimdall/httpclient"
+)
+
+const (
+	// consulTTLCheckPrefix prefixes the id of the service instance in the id of
+	// the ttl check which probed reports its verdicts to
+	consulTTLCheckPrefix = "probed:"
+	// consulMaintCheckPrefix prefixes the id of the service instance in the id
+	// of the check consul adds for an instance in maintenance
+	consulMaintCheckPrefix = "_service_maintenance:"
+	// consulMaintReason is the reason probed puts instances into maintenance
+	// with, consul keeps it in the notes of the maintenance check
+	consulMaintReason = "marked unhealthy by probed"
+)
+
+// consulClient is a Client for the catalog of consul. Services are upstreams
+// and their instances are targets, probed's verdicts are reported to the
+// agent of an instance through a ttl check or by putting the instance into
+// maintenance, so that load balancers configured from the health of consul
+// route around unhealthy instances.
+type consulClient struct {
+	httpClient httpDoer
+	consulURL  *url.URL
+	agentPort  int
+	token      string
+	maint      bool
+
+	instances *targetIDs
+
+	mu       sync.Mutex
+	verdicts map[targetKey]bool
+}
+
+// consulInstance is a service instance and the address of the agent it is
+// registered with
+type consulInstance struct {
+	serviceID string
+	agentURL  string
+	hasTTL    bool
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string `json:"ID"`
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+	Checks []struct {
+		CheckID string `json:"CheckID"`
+		Status  string `json:"Status"`
+		Notes   string `json:"Notes"`
+	} `json:"Checks"`
+}
+
+// newConsulClient talks to the catalog through the agent at consulURL, and to
+// the agents of instances at agentPort of their node. Verdicts go to ttl
+// checks, or to maintenance of instances when maint is set.
+func newConsulClient(consulURL string, agentPort int, token string, maint bool, timeout time.Duration) (*consulClient, error) {
+	if timeout == 0 {
+		timeout = 1000 * time.Millisecond
+	}
+
+	parsedURL, err := url.Parse(strings.TrimSuffix(consulURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid consul url %q: %s", consulURL, err)
+	}
+
+	return &consulClient{
+		httpClient: httpclient.NewClient(httpclient.WithHTTPTimeout(timeout)),
+		consulURL:  parsedURL,
+		agentPort:  agentPort,
+		token:      token,
+		maint:      maint,
+		instances:  newTargetIDs(),
+		verdicts:   make(map[targetKey]bool),
+	}, nil
+}
+
+func (cc *consulClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+
+	respBytes, err := cc.doRequest(http.MethodGet, cc.consulURL.String(), "v1/catalog/services", nil)
+	if err != nil {
+		return upstreams, err
+	}
+
+	services := map[string][]string{}
+	err = json.Unmarshal(respBytes, &services)
+	if err != nil {
+		return upstreams, err
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		if name == "consul" {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		upstreams = append(upstreams, upstream{ID: name, Name: name})
+	}
+
+	return upstreams, nil
+}
+
+// targetsFor lists the instances of a service. Instances which probed put
+// into maintenance, or reported as unhealthy to their ttl check, have a weight
+// of 0. Instances in maintenance for other reasons are left alone. Without a
+// verdict of probed the status of a ttl check is unknown, a new check starts
+// out critical, so the instance is listed as healthy until it is checked. The
+// verdicts of probed are reported again, so that the checks do not expire
+// while probed is running.
+func (cc *consulClient) targetsFor(upstreamID string) ([]target, error) {
+	targets := []target{}
+
+	respBytes, err := cc.doRequest(http.MethodGet, cc.consulURL.String(), fmt.Sprintf("v1/health/service/%s", url.PathEscape(upstreamID)), nil)
+	if err != nil {
+		return targets, err
+	}
+
+	entries := []consulServiceEntry{}
+	err = json.Unmarshal(respBytes, &entries)
+	if err != nil {
+		return targets, err
+	}
+
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+
+		t := target{
+			ID:         entry.Service.ID,
+			URL:        net.JoinHostPort(address, strconv.Itoa(entry.Service.Port)),
+			Weight:     healthyNodeWeight,
+			UpstreamID: upstreamID,
+		}
+
+		instance := consulInstance{
+			serviceID: entry.Service.ID,
+			agentURL:  cc.agentURL(entry.Node.Address),
+		}
+
+		unhealthy, operatorMaint := false, false
+		for _, check := range entry.Checks {
+			switch check.CheckID {
+			case consulMaintCheckPrefix + entry.Service.ID:
+				unhealthy = check.Notes == consulMaintReason
+				operatorMaint = !unhealthy
+			case consulTTLCheckPrefix + entry.Service.ID:
+				instance.hasTTL = true
+			}
+		}
+
+		if cc.maint && operatorMaint {
+			continue
+		}
+
+		if !cc.maint {
+			cc.mu.Lock()
+			verdict, ok := cc.verdicts[keyFor(t)]
+			cc.mu.Unlock()
+
+			unhealthy = verdict
+			if ok && instance.hasTTL {
+				err := cc.updateTTLCheck(instance, verdict)
+				if err != nil {
+					log.Printf("failed to update ttl check of instance %s: reason: %s", instance.serviceID, err)
+				}
+			}
+		}
+
+		cc.instances.set(t, instance)
+
+		if unhealthy {
+			t.Weight = unhealthyNodeWeight
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// needsVerdict reports whether the ttl check of an instance has not been
+// updated by probed yet, so that it is reported as healthy once it passes its
+// checks.
+func (cc *consulClient) needsVerdict(upstreamID, targetURL string) bool {
+	if cc.maint {
+		return false
+	}
+
+	key := targetKey{upstreamID: upstreamID, url: targetURL}
+
+	cc.mu.Lock()
+	_, ok := cc.verdicts[key]
+	cc.mu.Unlock()
+	if ok {
+		return false
+	}
+
+	id, err := cc.instances.lookup(upstreamID, targetURL, cc.targetsFor)
+	if err != nil {
+		return false
+	}
+
+	return id.(consulInstance).hasTTL
+}
+
+// setTargetWeightFor reports an instance as unhealthy for a weight of 0, and
+// as healthy otherwise, consul does not support weights of instances.
+func (cc *consulClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	key := targetKey{upstreamID: upstreamID, url: targetURL}
+	unhealthy := weight <= unhealthyNodeWeight
+
+	id, err := cc.instances.lookup(upstreamID, targetURL, cc.targetsFor)
+	if err != nil {
+		return err
+	}
+	instance := id.(consulInstance)
+
+	if cc.maint {
+		query := url.Values{"enable": []string{strconv.FormatBool(unhealthy)}, "reason": []string{consulMaintReason}}
+		_, err := cc.doRequest(http.MethodPut, instance.agentURL, fmt.Sprintf("v1/agent/service/maintenance/%s?%s", url.PathEscape(instance.serviceID), query.Encode()), nil)
+		return err
+	}
+
+	if !instance.hasTTL {
+		return fmt.Errorf("no ttl check %s%s for instance %s", consulTTLCheckPrefix, instance.serviceID, instance.serviceID)
+	}
+
+	err = cc.updateTTLCheck(instance, unhealthy)
+	if err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	cc.verdicts[key] = unhealthy
+	cc.mu.Unlock()
+
+	return nil
+}
+
+func (cc *consulClient) updateTTLCheck(instance consulInstance, unhealthy bool) error {
+	status, output := "passing", "healthy according to probed"
+	if unhealthy {
+		status, output = "critical", "unhealthy according to probed"
+	}
+
+	requestBody, err := json.Marshal(map[string]string{"Status": status, "Output": output})
+	if err != nil {
+		return err
+	}
+
+	_, err = cc.doRequest(http.MethodPut, instance.agentURL, fmt.Sprintf("v1/agent/check/update/%s", url.PathEscape(consulTTLCheckPrefix+instance.serviceID)), requestBody)
+	return err
+}
+
+// agentURL is the url of the agent on a node, with the scheme of the consul
+// url
+func (cc *consulClient) agentURL(nodeAddress string) string {
+	return fmt.Sprintf("%s://%s", cc.consulURL.Scheme, net.JoinHostPort(nodeAddress, strconv.Itoa(cc.agentPort)))
+}
+
+func (cc *consulClient) doRequest(method, baseURL, path string, body []byte) ([]byte, error) {
+	return doJSONRequest(cc.httpClient, method, fmt.Sprintf("%s/%s", baseURL, path), body, cc.applyToken)
+}
+
+func (cc *consulClient) applyToken(req *http.Request) {
+	if cc.token != "" {
+		req.Header.Set("X-Consul-Token", cc.token)
+	}
+}
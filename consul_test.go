@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type consulUpdate struct {
+	path  string
+	query string
+	body  map[string]string
+}
+
+// newConsulAPI fakes the http api of consul, serving both the catalog and
+// the agent on 127.0.0.1 with a service orders of two instances. Updates of
+// checks and maintenance are sent on the returned channel.
+func newConsulAPI(t *testing.T, checks string) (*httptest.Server, chan consulUpdate) {
+	updates := make(chan consulUpdate, 10)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Consul-Token"))
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1/catalog/services":
+			w.Write([]byte(`{"consul": [], "search": ["v2"], "orders": ["http"]}`))
+		case "GET /v1/health/service/orders":
+			w.Write([]byte(fmt.Sprintf(`[
+				{"Node": {"Node": "node1", "Address": "127.0.0.1"}, "Service": {"ID": "orders-1", "Service": "orders", "Address": "10.0.0.1", "Port": 8080}, "Checks": [{"CheckID": "serfHealth", "Status": "passing"}, %s]},
+				{"Node": {"Node": "node2", "Address": "10.0.0.2"}, "Service": {"ID": "orders-2", "Service": "orders", "Address": "", "Port": 8080}, "Checks": [{"CheckID": "serfHealth", "Status": "passing"}]}
+			]`, checks)))
+		case "PUT /v1/agent/check/update/probed:orders-1", "PUT /v1/agent/service/maintenance/orders-1":
+			update := consulUpdate{path: r.URL.Path, query: r.URL.RawQuery}
+
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			if len(body) > 0 {
+				assert.NoError(t, json.Unmarshal(body, &update.body))
+			}
+
+			updates <- update
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return httpServer, updates
+}
+
+func newTestConsulClient(t *testing.T, httpServer *httptest.Server, maint bool) *consulClient {
+	_, port, err := net.SplitHostPort(httpServer.Listener.Addr().String())
+	require.NoError(t, err)
+
+	agentPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	cc, err := newConsulClient(httpServer.URL, agentPort, "secret", maint, 0)
+	require.NoError(t, err)
+
+	return cc
+}
+
+func TestConsulClientListsServicesAsUpstreams(t *testing.T) {
+	httpServer, _ := newConsulAPI(t, `{"CheckID": "probed:orders-1", "Status": "passing"}`)
+	defer httpServer.Close()
+
+	upstreams, err := newTestConsulClient(t, httpServer, false).upstreams()
+	require.NoError(t, err)
+	assert.Equal(t, []upstream{{ID: "orders", Name: "orders"}, {ID: "search", Name: "search"}}, upstreams)
+}
+
+func TestConsulClientListsInstancesWithoutVerdictsAsHealthy(t *testing.T) {
+	httpServer, updates := newConsulAPI(t, `{"CheckID": "probed:orders-1", "Status": "critical"}`)
+	defer httpServer.Close()
+
+	cc := newTestConsulClient(t, httpServer, false)
+
+	targets, err := cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{ID: "orders-1", URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "orders"},
+		{ID: "orders-2", URL: "10.0.0.2:8080", Weight: 100, UpstreamID: "orders"},
+	}, targets, "should check instances whose ttl check has not been updated by probed")
+	assert.Empty(t, updates, "should not refresh checks without a verdict")
+
+	assert.True(t, cc.needsVerdict("orders", "10.0.0.1:8080"))
+	assert.False(t, cc.needsVerdict("orders", "10.0.0.2:8080"), "should not need a verdict without a ttl check")
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:8080", 100))
+	assert.Equal(t, "passing", (<-updates).body["Status"])
+	assert.False(t, cc.needsVerdict("orders", "10.0.0.1:8080"))
+}
+
+func TestConsulClientReportsVerdictsToTTLChecks(t *testing.T) {
+	httpServer, updates := newConsulAPI(t, `{"CheckID": "probed:orders-1", "Status": "passing"}`)
+	defer httpServer.Close()
+
+	cc := newTestConsulClient(t, httpServer, false)
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:8080", 0))
+
+	update := <-updates
+	assert.Equal(t, "/v1/agent/check/update/probed:orders-1", update.path)
+	assert.Equal(t, "critical", update.body["Status"])
+
+	targets, err := cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 0, targets[0].Weight, "should report the verdict of probed before consul caught up")
+	assert.Equal(t, 100, targets[1].Weight)
+	assert.Equal(t, "critical", (<-updates).body["Status"], "should have reported the verdict again")
+	assert.Empty(t, updates, "should only refresh instances with a verdict")
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:8080", 100))
+	assert.Equal(t, "passing", (<-updates).body["Status"])
+
+	assert.Error(t, cc.setTargetWeightFor("orders", "10.0.0.2:8080", 0), "should fail without a ttl check")
+	assert.Error(t, cc.setTargetWeightFor("orders", "10.0.0.9:8080", 0), "should fail for unknown instances")
+}
+
+func TestConsulClientPutsInstancesIntoMaintenance(t *testing.T) {
+	httpServer, updates := newConsulAPI(t, `{"CheckID": "_service_maintenance:orders-1", "Status": "critical", "Notes": "marked unhealthy by probed"}`)
+	defer httpServer.Close()
+
+	cc := newTestConsulClient(t, httpServer, true)
+
+	targets, err := cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 0, targets[0].Weight)
+	assert.Equal(t, 100, targets[1].Weight)
+	assert.Empty(t, updates, "should not update ttl checks in maintenance mode")
+	assert.False(t, cc.needsVerdict("orders", "10.0.0.1:8080"))
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:8080", 100))
+
+	update := <-updates
+	assert.Equal(t, "/v1/agent/service/maintenance/orders-1", update.path)
+	assert.Equal(t, "enable=false&reason=marked+unhealthy+by+probed", update.query)
+
+	require.NoError(t, cc.setTargetWeightFor("orders", "10.0.0.1:8080", 0))
+	assert.Equal(t, "enable=true&reason=marked+unhealthy+by+probed", (<-updates).query)
+}
+
+func TestConsulClientLeavesMaintenanceOfOperatorsAlone(t *testing.T) {
+	httpServer, updates := newConsulAPI(t, `{"CheckID": "_service_maintenance:orders-1", "Status": "critical", "Notes": "kernel upgrade"}`)
+	defer httpServer.Close()
+
+	cc := newTestConsulClient(t, httpServer, true)
+
+	targets, err := cc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{ID: "orders-2", URL: "10.0.0.2:8080", Weight: 100, UpstreamID: "orders"}}, targets)
+
+	assert.Error(t, cc.setTargetWeightFor("orders", "10.0.0.1:8080", 100), "should not take the instance out of maintenance")
+	assert.Empty(t, updates)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dnsLookupTimeout = 5 * time.Second
+
+// newDNSResolver resolves names with the servers of the system, or with the
+// server at host:port when it is set
+func newDNSResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// lookupAddrs resolves a host to the addresses of its A and AAAA records, an
+// ip address resolves to itself
+func lookupAddrs(ctx context.Context, resolver *net.Resolver, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	ipAddrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(ipAddrs))
+	for _, ipAddr := range ipAddrs {
+		addrs = append(addrs, ipAddr.IP.String())
+	}
+
+	return addrs, nil
+}
+
+// isSRVName reports whether an upstream is the name of an SRV record like
+// _http._tcp.orders.service.consul, rather than a host:port
+func isSRVName(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// dnsClient is a Client for upstreams defined as dns names, like the
+// upstreams of kong's ring balancer in dns mode. An upstream is either the
+// name of an SRV record, or a host:port whose A and AAAA records are used
+// with the port. Every resolved address is a target, resolved again on every
+// tick. There is nowhere to write weights to, so the dns backend is only used
+// with -eds-listen, which serves the weights set by probed.
+type dnsClient struct {
+	resolver *net.Resolver
+	names    []string
+
+	mu      sync.Mutex
+	weights map[targetKey]int
+}
+
+// newDNSClient resolves the comma separated names with the servers of the
+// system, or with server when it is set
+func newDNSClient(names, server string) (*dnsClient, error) {
+	dc := &dnsClient{
+		resolver: newDNSResolver(server),
+		weights:  make(map[targetKey]int),
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !isSRVName(name) {
+			_, _, err := net.SplitHostPort(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dns upstream %q, expected an SRV name or host:port: %s", name, err)
+			}
+		}
+
+		dc.names = append(dc.names, name)
+	}
+
+	if len(dc.names) == 0 {
+		return nil, fmt.Errorf("no dns upstreams to check")
+	}
+
+	return dc, nil
+}
+
+func (dc *dnsClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+
+	for _, name := range dc.names {
+		upstreams = append(upstreams, upstream{ID: name, Name: name})
+	}
+
+	return upstreams, nil
+}
+
+// targetsFor resolves an upstream to its addresses, with the weights set by
+// probed for addresses which are still resolved
+func (dc *dnsClient) targetsFor(upstreamID string) ([]target, error) {
+	targets := []target{}
+
+	addrs, err := dc.resolve(upstreamID)
+	if err != nil {
+		return targets, err
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	resolved := make(map[targetKey]bool, len(addrs))
+	for _, addr := range addrs {
+		t := target{URL: addr, Weight: healthyNodeWeight, UpstreamID: upstreamID}
+		if weight, ok := dc.weights[keyFor(t)]; ok {
+			t.Weight = weight
+		}
+
+		resolved[keyFor(t)] = true
+		targets = append(targets, t)
+	}
+
+	for key := range dc.weights {
+		if key.upstreamID == upstreamID && !resolved[key] {
+			delete(dc.weights, key)
+		}
+	}
+
+	return targets, nil
+}
+
+func (dc *dnsClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.weights[targetKey{upstreamID: upstreamID, url: targetURL}] = weight
+	log.Printf("set weight of target %s of upstream %s to %d", targetURL, upstreamID, weight)
+
+	return nil
+}
+
+// resolve looks up the host:port of every address of an upstream, sorted
+// and without duplicates
+func (dc *dnsClient) resolve(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	type hostPort struct {
+		host string
+		port string
+	}
+
+	hostPorts := []hostPort{}
+	if isSRVName(name) {
+		_, srvs, err := dc.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, srv := range srvs {
+			hostPorts = append(hostPorts, hostPort{host: strings.TrimSuffix(srv.Target, "."), port: strconv.Itoa(int(srv.Port))})
+		}
+	} else {
+		host, port, err := net.SplitHostPort(name)
+		if err != nil {
+			return nil, err
+		}
+
+		hostPorts = append(hostPorts, hostPort{host: host, port: port})
+	}
+
+	seen := make(map[string]bool)
+	addrs := []string{}
+	for _, hp := range hostPorts {
+		ips, err := lookupAddrs(ctx, dc.resolver, hp.host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ip := range ips {
+			addr := net.JoinHostPort(ip, hp.port)
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	sort.Strings(addrs)
+	return addrs, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startDNSServer serves the records, in the zone file format like
+// "orders.probed.test. 60 IN A 10.0.0.1", from an in-process dns server and
+// returns its address
+func startDNSServer(t *testing.T, records ...string) (string, func()) {
+	rrs := []dns.RR{}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		require.NoError(t, err)
+		rrs = append(rrs, rr)
+	}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{PacketConn: packetConn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		msg.Authoritative = true
+
+		found := false
+		for _, question := range req.Question {
+			for _, rr := range rrs {
+				if rr.Header().Name != question.Name {
+					continue
+				}
+
+				found = true
+				if rr.Header().Rrtype == question.Qtype {
+					msg.Answer = append(msg.Answer, rr)
+				}
+			}
+		}
+
+		if !found {
+			msg.Rcode = dns.RcodeNameError
+		}
+
+		w.WriteMsg(msg)
+	})}
+
+	started := make(chan bool)
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+
+	return packetConn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func TestNewDNSClient(t *testing.T) {
+	dc, err := newDNSClient("_http._tcp.orders.probed.test, search.probed.test:9200", "")
+	require.NoError(t, err)
+
+	upstreams, err := dc.upstreams()
+	require.NoError(t, err)
+	assert.Equal(t, []upstream{
+		{ID: "_http._tcp.orders.probed.test", Name: "_http._tcp.orders.probed.test"},
+		{ID: "search.probed.test:9200", Name: "search.probed.test:9200"},
+	}, upstreams)
+
+	_, err = newDNSClient("search.probed.test", "")
+	assert.Error(t, err, "should require a port for A records")
+
+	_, err = newDNSClient("", "")
+	assert.Error(t, err, "should require upstreams")
+}
+
+func TestDNSClientResolvesSRVRecordsToTargets(t *testing.T) {
+	addr, stop := startDNSServer(t,
+		"_http._tcp.orders.probed.test. 60 IN SRV 10 50 8080 orders-a.probed.test.",
+		"_http._tcp.orders.probed.test. 60 IN SRV 10 50 8081 orders-b.probed.test.",
+		"orders-a.probed.test. 60 IN A 10.0.0.1",
+		"orders-a.probed.test. 60 IN A 10.0.0.2",
+		"orders-b.probed.test. 60 IN AAAA fd00::3",
+	)
+	defer stop()
+
+	dc, err := newDNSClient("_http._tcp.orders.probed.test", addr)
+	require.NoError(t, err)
+
+	targets, err := dc.targetsFor("_http._tcp.orders.probed.test")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "_http._tcp.orders.probed.test"},
+		{URL: "10.0.0.2:8080", Weight: 100, UpstreamID: "_http._tcp.orders.probed.test"},
+		{URL: "[fd00::3]:8081", Weight: 100, UpstreamID: "_http._tcp.orders.probed.test"},
+	}, targets)
+}
+
+func TestDNSClientResolvesARecordsToTargetsWithWeights(t *testing.T) {
+	addr, stop := startDNSServer(t,
+		"search.probed.test. 60 IN A 10.0.0.1",
+		"search.probed.test. 60 IN A 10.0.0.2",
+	)
+	defer stop()
+
+	dc, err := newDNSClient("search.probed.test:9200", addr)
+	require.NoError(t, err)
+
+	require.NoError(t, dc.setTargetWeightFor("search.probed.test:9200", "10.0.0.2:9200", 0))
+	require.NoError(t, dc.setTargetWeightFor("search.probed.test:9200", "10.0.0.9:9200", 0))
+
+	targets, err := dc.targetsFor("search.probed.test:9200")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.1:9200", Weight: 100, UpstreamID: "search.probed.test:9200"},
+		{URL: "10.0.0.2:9200", Weight: 0, UpstreamID: "search.probed.test:9200"},
+	}, targets)
+	assert.Len(t, dc.weights, 1, "should have forgotten the weight of an address which is not resolved")
+
+	_, err = dc.targetsFor("missing.probed.test:9200")
+	assert.Error(t, err)
+}
+
+func TestDNSClientTargetsOfAAAARecordsAreTCPChecked(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6 loopback")
+	}
+	defer listener.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	addr, stop := startDNSServer(t, "search.probed.test. 60 IN AAAA ::1")
+	defer stop()
+
+	dc, err := newDNSClient(net.JoinHostPort("search.probed.test", port), addr)
+	require.NoError(t, err)
+
+	targets, err := dc.targetsFor(net.JoinHostPort("search.probed.test", port))
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, net.JoinHostPort("::1", port), targets[0].URL)
+
+	assert.NoError(t, tcpChecker{}.check(targets[0]), "should dial the ipv6 address of the target")
+}
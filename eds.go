@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// edsClient is a Client which turns probed into an envoy endpoint discovery
+// service. Upstreams and targets come from a source Client like kong, and
+// every upstream is served as a cluster of the same name with the health and
+// weight of its endpoints taken from the checks of probed. Weights are never
+// written back to the source.
+type edsClient struct {
+	source Client
+	cache  *cachev3.LinearCache
+
+	mu       sync.Mutex
+	clusters map[string]string
+	targets  map[string][]target
+	weights  map[targetKey]int
+}
+
+func newEDSClient(source Client) *edsClient {
+	return &edsClient{
+		source:   source,
+		cache:    cachev3.NewLinearCache(resourcev3.EndpointType),
+		clusters: make(map[string]string),
+		targets:  make(map[string][]target),
+		weights:  make(map[targetKey]int),
+	}
+}
+
+// upstreams lists the upstreams of the source, each served as the cluster of
+// its name. Upstreams whose name is already served for another upstream, like
+// the http and stream upstreams of nginx, are left out, and the clusters of
+// upstreams which are gone from the source are no longer served.
+func (ec *edsClient) upstreams() ([]upstream, error) {
+	upstreams, err := ec.source.upstreams()
+	if err != nil {
+		return upstreams, err
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	served := []upstream{}
+	clusters := make(map[string]string, len(upstreams))
+	upstreamIDs := make(map[string]string, len(upstreams))
+	for _, u := range upstreams {
+		if upstreamID, ok := upstreamIDs[u.Name]; ok {
+			log.Printf("failed to serve upstream %s: reason: cluster %s is already served for upstream %s", u.ID, u.Name, upstreamID)
+			continue
+		}
+
+		clusters[u.ID] = u.Name
+		upstreamIDs[u.Name] = u.ID
+		served = append(served, u)
+	}
+
+	for upstreamID, cluster := range ec.clusters {
+		if clusters[upstreamID] == cluster {
+			continue
+		}
+
+		if _, ok := clusters[upstreamID]; !ok {
+			ec.forget(upstreamID)
+		}
+
+		if _, ok := upstreamIDs[cluster]; ok {
+			continue
+		}
+
+		err := ec.cache.DeleteResource(cluster)
+		if err != nil {
+			log.Printf("failed to stop serving cluster %s: reason: %s", cluster, err)
+		}
+	}
+
+	ec.clusters = clusters
+	return served, nil
+}
+
+// forget drops the targets and weights of an upstream which is no longer
+// served
+func (ec *edsClient) forget(upstreamID string) {
+	delete(ec.targets, upstreamID)
+
+	for key := range ec.weights {
+		if key.upstreamID == upstreamID {
+			delete(ec.weights, key)
+		}
+	}
+}
+
+// targetsFor lists the targets of an upstream from the source, with the
+// weight probed has set for them, and serves them as the endpoints of the
+// cluster of the upstream.
+func (ec *edsClient) targetsFor(upstreamID string) ([]target, error) {
+	targets, err := ec.source.targetsFor(upstreamID)
+	if err != nil {
+		return targets, err
+	}
+
+	targets = newestTargets(targets)
+	for i := range targets {
+		targets[i].UpstreamID = upstreamID
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ec.targets[upstreamID] = append([]target{}, targets...)
+
+	current := make(map[targetKey]bool, len(targets))
+	for i := range targets {
+		key := keyFor(targets[i])
+		current[key] = true
+
+		if weight, ok := ec.weights[key]; ok {
+			targets[i].Weight = weight
+		}
+	}
+
+	for key := range ec.weights {
+		if key.upstreamID == upstreamID && !current[key] {
+			delete(ec.weights, key)
+		}
+	}
+
+	return targets, ec.publish(upstreamID)
+}
+
+// setTargetWeightFor keeps the weight of a target and serves it to envoy, a
+// weight of 0 serves the endpoint as unhealthy.
+func (ec *edsClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ec.weights[targetKey{upstreamID: upstreamID, url: targetURL}] = weight
+	return ec.publish(upstreamID)
+}
+
+// publish serves the endpoints of the cluster of an upstream. Targets which
+// are disabled in the source are left out, targets probed marked unhealthy
+// keep their weight in the source.
+func (ec *edsClient) publish(upstreamID string) error {
+	cluster, ok := ec.clusters[upstreamID]
+	if !ok {
+		return fmt.Errorf("upstream %s is not served as a cluster", upstreamID)
+	}
+
+	endpoints := []*endpointv3.LbEndpoint{}
+	for _, t := range ec.targets[upstreamID] {
+		if t.Weight <= unhealthyNodeWeight {
+			continue
+		}
+
+		endpoint, err := lbEndpointFor(t)
+		if err != nil {
+			log.Printf("failed to serve target %s of cluster %s: reason: %s", t.URL, cluster, err)
+			continue
+		}
+
+		if weight, ok := ec.weights[keyFor(t)]; ok {
+			if weight <= unhealthyNodeWeight {
+				endpoint.HealthStatus = corev3.HealthStatus_UNHEALTHY
+			} else {
+				endpoint.LoadBalancingWeight = wrapperspb.UInt32(uint32(weight))
+			}
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	assignment := &endpointv3.ClusterLoadAssignment{
+		ClusterName: cluster,
+		Endpoints:   []*endpointv3.LocalityLbEndpoints{{LbEndpoints: endpoints}},
+	}
+
+	return ec.cache.UpdateResource(cluster, assignment)
+}
+
+func lbEndpointFor(t target) (*endpointv3.LbEndpoint, error) {
+	host, portString, err := net.SplitHostPort(t.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portString)
+	}
+
+	return &endpointv3.LbEndpoint{
+		HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+			Endpoint: &endpointv3.Endpoint{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{
+							Address:       host,
+							PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(port)},
+						},
+					},
+				},
+			},
+		},
+		HealthStatus:        corev3.HealthStatus_HEALTHY,
+		LoadBalancingWeight: wrapperspb.UInt32(uint32(t.Weight)),
+	}, nil
+}
+
+// serve serves endpoint discovery to envoy on the listener, over both eds and
+// ads, until the context is done
+func (ec *edsClient) serve(ctx context.Context, listener net.Listener) error {
+	grpcServer := grpc.NewServer()
+	xdsServer := serverv3.NewServer(ctx, ec.cache, nil)
+
+	endpointservice.RegisterEndpointDiscoveryServiceServer(grpcServer, xdsServer)
+	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdsServer)
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+
+	return grpcServer.Serve(listener)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type edsSubscription struct {
+	stream endpointservice.EndpointDiscoveryService_StreamEndpointsClient
+}
+
+// subscribeToEDS subscribes to the endpoints of a cluster like envoy does
+func subscribeToEDS(t *testing.T, ec *edsClient, cluster string) (*edsSubscription, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go ec.serve(ctx, listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	stream, err := endpointservice.NewEndpointDiscoveryServiceClient(conn).StreamEndpoints(ctx)
+	require.NoError(t, err)
+
+	err = stream.Send(&discoveryv3.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "sidecar"},
+		TypeUrl:       resourcev3.EndpointType,
+		ResourceNames: []string{cluster},
+	})
+	require.NoError(t, err)
+
+	return &edsSubscription{stream: stream}, func() {
+		conn.Close()
+		cancel()
+	}
+}
+
+// receive waits for the endpoints of the cluster and acks them
+func (es *edsSubscription) receive(t *testing.T) *endpointv3.ClusterLoadAssignment {
+	response := es.receiveResponse(t)
+	require.Equal(t, 1, len(response.Resources))
+
+	assignment := &endpointv3.ClusterLoadAssignment{}
+	require.NoError(t, response.Resources[0].UnmarshalTo(assignment))
+
+	err := es.stream.Send(&discoveryv3.DiscoveryRequest{
+		TypeUrl:       resourcev3.EndpointType,
+		ResourceNames: []string{assignment.ClusterName},
+		VersionInfo:   response.VersionInfo,
+		ResponseNonce: response.Nonce,
+	})
+	require.NoError(t, err)
+
+	return assignment
+}
+
+func (es *edsSubscription) receiveResponse(t *testing.T) *discoveryv3.DiscoveryResponse {
+	received := make(chan *discoveryv3.DiscoveryResponse, 1)
+	go func() {
+		response, err := es.stream.Recv()
+		if err == nil {
+			received <- response
+		}
+	}()
+
+	var response *discoveryv3.DiscoveryResponse
+	select {
+	case response = <-received:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "should have received endpoints")
+	}
+
+	return response
+}
+
+type servedEndpoint struct {
+	address string
+	port    uint32
+	weight  uint32
+	health  corev3.HealthStatus
+}
+
+func servedEndpoints(assignment *endpointv3.ClusterLoadAssignment) []servedEndpoint {
+	endpoints := []servedEndpoint{}
+	for _, locality := range assignment.Endpoints {
+		for _, lbEndpoint := range locality.LbEndpoints {
+			socketAddress := lbEndpoint.GetEndpoint().Address.GetSocketAddress()
+			endpoints = append(endpoints, servedEndpoint{
+				address: socketAddress.Address,
+				port:    socketAddress.GetPortValue(),
+				weight:  lbEndpoint.LoadBalancingWeight.GetValue(),
+				health:  lbEndpoint.HealthStatus,
+			})
+		}
+	}
+
+	return endpoints
+}
+
+func TestEDSClientServesTargetsAsEndpoints(t *testing.T) {
+	source := new(mockClient)
+	source.On("upstreams").Return([]upstream{{ID: "1", Name: "orders"}}, nil)
+	source.On("targetsFor", "1").Return([]target{
+		{URL: "10.0.0.1:8080", Weight: 100, CreatedAt: 1},
+		{URL: "10.0.0.2:8080", Weight: 0, CreatedAt: 1},
+		{URL: "10.0.0.3:8080", Weight: 100, CreatedAt: 1},
+		{URL: "10.0.0.3:8080", Weight: 50, CreatedAt: 2},
+	}, nil)
+
+	ec := newEDSClient(source)
+
+	_, err := ec.upstreams()
+	require.NoError(t, err)
+	targets, err := ec.targetsFor("1")
+	require.NoError(t, err, "should not have failed to get targets")
+	assert.Equal(t, 3, len(targets), "should have collapsed the history of targets")
+
+	subscription, stop := subscribeToEDS(t, ec, "orders")
+	defer stop()
+
+	assignment := subscription.receive(t)
+	assert.Equal(t, "orders", assignment.ClusterName)
+	assert.Equal(t, []servedEndpoint{
+		{address: "10.0.0.1", port: 8080, weight: 100, health: corev3.HealthStatus_HEALTHY},
+		{address: "10.0.0.3", port: 8080, weight: 50, health: corev3.HealthStatus_HEALTHY},
+	}, servedEndpoints(assignment), "should have left out targets disabled in the source")
+}
+
+func TestEDSClientServesResultsOfChecks(t *testing.T) {
+	source := new(mockClient)
+	source.On("upstreams").Return([]upstream{{ID: "1", Name: "orders"}}, nil)
+	source.On("targetsFor", "1").Return([]target{
+		{URL: "10.0.0.1:8080", Weight: 100},
+		{URL: "10.0.0.2:8080", Weight: 100},
+	}, nil)
+
+	ec := newEDSClient(source)
+
+	_, err := ec.upstreams()
+	require.NoError(t, err)
+	_, err = ec.targetsFor("1")
+	require.NoError(t, err)
+
+	subscription, stop := subscribeToEDS(t, ec, "orders")
+	defer stop()
+	subscription.receive(t)
+
+	require.NoError(t, ec.setTargetWeightFor("1", "10.0.0.2:8080", 0))
+	assert.Equal(t, []servedEndpoint{
+		{address: "10.0.0.1", port: 8080, weight: 100, health: corev3.HealthStatus_HEALTHY},
+		{address: "10.0.0.2", port: 8080, weight: 100, health: corev3.HealthStatus_UNHEALTHY},
+	}, servedEndpoints(subscription.receive(t)))
+
+	targets, err := ec.targetsFor("1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, targets[1].Weight, "should report the weight set by probed")
+	subscription.receive(t)
+
+	require.NoError(t, ec.setTargetWeightFor("1", "10.0.0.2:8080", 20))
+	assert.Equal(t, []servedEndpoint{
+		{address: "10.0.0.1", port: 8080, weight: 100, health: corev3.HealthStatus_HEALTHY},
+		{address: "10.0.0.2", port: 8080, weight: 20, health: corev3.HealthStatus_HEALTHY},
+	}, servedEndpoints(subscription.receive(t)))
+
+	source.AssertNotCalled(t, "setTargetWeightFor", "1", "10.0.0.2:8080", 0)
+}
+
+func TestEDSClientStopsServingClustersOfRemovedUpstreams(t *testing.T) {
+	source := new(mockClient)
+	source.On("upstreams").Return([]upstream{{ID: "1", Name: "orders"}}, nil).Once()
+	source.On("upstreams").Return([]upstream{}, nil).Once()
+	source.On("targetsFor", "1").Return([]target{{URL: "10.0.0.1:8080", Weight: 100}}, nil)
+
+	ec := newEDSClient(source)
+
+	_, err := ec.upstreams()
+	require.NoError(t, err)
+	_, err = ec.targetsFor("1")
+	require.NoError(t, err)
+	require.NoError(t, ec.setTargetWeightFor("1", "10.0.0.1:8080", 0))
+
+	subscription, stop := subscribeToEDS(t, ec, "orders")
+	defer stop()
+	subscription.receive(t)
+	require.Eventually(t, func() bool { return ec.cache.NumWatches("orders") == 1 }, 5*time.Second, 10*time.Millisecond, "should have watched the cluster again")
+
+	upstreams, err := ec.upstreams()
+	require.NoError(t, err)
+	assert.Empty(t, upstreams)
+
+	assert.Empty(t, subscription.receiveResponse(t).Resources, "should have stopped serving the cluster")
+	assert.Empty(t, ec.targets)
+	assert.Empty(t, ec.weights)
+	assert.Error(t, ec.setTargetWeightFor("1", "10.0.0.1:8080", 100), "should not serve the cluster again")
+}
+
+func TestEDSClientServesOnlyTheFirstUpstreamOfAName(t *testing.T) {
+	source := new(mockClient)
+	source.On("upstreams").Return([]upstream{{ID: "http/orders", Name: "orders"}, {ID: "stream/orders", Name: "orders"}}, nil)
+	source.On("targetsFor", "http/orders").Return([]target{{URL: "10.0.0.1:8080", Weight: 100}}, nil)
+
+	ec := newEDSClient(source)
+
+	upstreams, err := ec.upstreams()
+	require.NoError(t, err)
+	assert.Equal(t, []upstream{{ID: "http/orders", Name: "orders"}}, upstreams)
+
+	_, err = ec.targetsFor("http/orders")
+	require.NoError(t, err)
+
+	subscription, stop := subscribeToEDS(t, ec, "orders")
+	defer stop()
+
+	assert.Equal(t, []servedEndpoint{
+		{address: "10.0.0.1", port: 8080, weight: 100, health: corev3.HealthStatus_HEALTHY},
+	}, servedEndpoints(subscription.receive(t)))
+}
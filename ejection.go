@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ejectionLimit is the maximum no of targets of an upstream which can be
+// marked unhealthy at the same time, either as an absolute count or as a
+// percentage of the targets of the upstream.
+type ejectionLimit struct {
+	value   int
+	percent bool
+}
+
+func parseEjectionLimit(limit string) (*ejectionLimit, error) {
+	if limit == "" {
+		return nil, nil
+	}
+
+	percent := strings.HasSuffix(limit, "%")
+	value, err := strconv.Atoi(strings.TrimSuffix(limit, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ejection limit %q: %s", limit, err)
+	}
+
+	if value < 0 || (percent && value > 100) {
+		return nil, fmt.Errorf("invalid ejection limit %q: out of range", limit)
+	}
+
+	return &ejectionLimit{value: value, percent: percent}, nil
+}
+
+func (el ejectionLimit) maxFor(targetCount int) int {
+	if el.percent {
+		return targetCount * el.value / 100
+	}
+
+	return el.value
+}
+
+func (el ejectionLimit) String() string {
+	if el.percent {
+		return fmt.Sprintf("%d%%", el.value)
+	}
+
+	return strconv.Itoa(el.value)
+}
+
+type upstreamEjections struct {
+	targetCount int
+	ejected     map[string]bool
+}
+
+// ejectionGuard keeps a per upstream view of the targets which are marked
+// unhealthy and refuses to mark any more of them once the limit is reached.
+type ejectionGuard struct {
+	limit ejectionLimit
+
+	mu        sync.Mutex
+	upstreams map[string]*upstreamEjections
+}
+
+func newEjectionGuard(limit ejectionLimit) *ejectionGuard {
+	return &ejectionGuard{
+		limit:     limit,
+		upstreams: make(map[string]*upstreamEjections),
+	}
+}
+
+// observe updates the view of an upstream with the targets the loadbalancer
+// reports for it.
+func (eg *ejectionGuard) observe(upstreamID string, targets []target) {
+	if eg == nil {
+		return
+	}
+
+	ejected := make(map[string]bool)
+	for _, t := range targets {
+		if t.Weight <= 0 {
+			ejected[t.URL] = true
+		}
+	}
+
+	eg.mu.Lock()
+	defer eg.mu.Unlock()
+
+	eg.upstreams[upstreamID] = &upstreamEjections{targetCount: len(targets), ejected: ejected}
+}
+
+// reserve reserves an ejection for the target if its upstream is still
+// within the limit, the reservation is dropped with release if the target
+// could not be marked unhealthy. A nil guard allows every ejection.
+func (eg *ejectionGuard) reserve(t target) error {
+	if eg == nil {
+		return nil
+	}
+
+	eg.mu.Lock()
+	defer eg.mu.Unlock()
+
+	ue, ok := eg.upstreams[t.UpstreamID]
+	if !ok || ue.ejected[t.URL] {
+		return nil
+	}
+
+	maxEjected := eg.limit.maxFor(ue.targetCount)
+	if len(ue.ejected) >= maxEjected {
+		return fmt.Errorf("%d of %d targets are already unhealthy, limit is %s", len(ue.ejected), ue.targetCount, eg.limit)
+	}
+
+	ue.ejected[t.URL] = true
+	return nil
+}
+
+func (eg *ejectionGuard) release(t target) {
+	if eg == nil {
+		return
+	}
+
+	eg.mu.Lock()
+	defer eg.mu.Unlock()
+
+	ue, ok := eg.upstreams[t.UpstreamID]
+	if !ok {
+		return
+	}
+
+	delete(ue.ejected, t.URL)
+}
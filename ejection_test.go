@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEjectionLimit(t *testing.T) {
+	limit, err := parseEjectionLimit("")
+	require.NoError(t, err)
+	assert.Nil(t, limit)
+
+	limit, err = parseEjectionLimit("3")
+	require.NoError(t, err)
+	assert.Equal(t, &ejectionLimit{value: 3}, limit)
+	assert.Equal(t, 3, limit.maxFor(10))
+
+	limit, err = parseEjectionLimit("50%")
+	require.NoError(t, err)
+	assert.Equal(t, &ejectionLimit{value: 50, percent: true}, limit)
+	assert.Equal(t, 5, limit.maxFor(10))
+	assert.Equal(t, 1, limit.maxFor(3))
+}
+
+func TestParseEjectionLimitFailure(t *testing.T) {
+	for _, limit := range []string{"abc", "-1", "120%", "%"} {
+		_, err := parseEjectionLimit(limit)
+		assert.Error(t, err, "should have failed to parse %s", limit)
+	}
+}
+
+func TestEjectionGuardRefusesEjectionsPastTheLimit(t *testing.T) {
+	guard := newEjectionGuard(ejectionLimit{value: 50, percent: true})
+
+	guard.observe("upstream1", []target{
+		{URL: "1.2.3.4:80", Weight: 100, UpstreamID: "upstream1"},
+		{URL: "1.2.3.5:80", Weight: 100, UpstreamID: "upstream1"},
+		{URL: "1.2.3.6:80", Weight: 0, UpstreamID: "upstream1"},
+		{URL: "1.2.3.7:80", Weight: 100, UpstreamID: "upstream1"},
+	})
+
+	assert.NoError(t, guard.reserve(target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}))
+	assert.NoError(t, guard.reserve(target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}), "should allow an already reserved target")
+	assert.Error(t, guard.reserve(target{URL: "1.2.3.5:80", UpstreamID: "upstream1"}))
+
+	guard.release(target{URL: "1.2.3.6:80", UpstreamID: "upstream1"})
+	assert.NoError(t, guard.reserve(target{URL: "1.2.3.5:80", UpstreamID: "upstream1"}))
+}
+
+func TestEjectionGuardResetsViewOnObserve(t *testing.T) {
+	guard := newEjectionGuard(ejectionLimit{value: 1})
+	targets := []target{
+		{URL: "1.2.3.4:80", Weight: 100, UpstreamID: "upstream1"},
+		{URL: "1.2.3.5:80", Weight: 100, UpstreamID: "upstream1"},
+	}
+
+	guard.observe("upstream1", targets)
+	assert.NoError(t, guard.reserve(targets[0]))
+	assert.Error(t, guard.reserve(targets[1]))
+
+	guard.observe("upstream1", targets)
+	assert.NoError(t, guard.reserve(targets[1]))
+}
+
+func TestEjectionGuardAllowsUnobservedUpstreams(t *testing.T) {
+	var nilGuard *ejectionGuard
+	guard := newEjectionGuard(ejectionLimit{value: 0})
+	tgt := target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}
+
+	assert.NoError(t, nilGuard.reserve(tgt))
+	assert.NoError(t, guard.reserve(tgt))
+
+	guard.observe("upstream1", []target{{URL: "1.2.3.4:80", Weight: 100, UpstreamID: "upstream1"}})
+	assert.Error(t, guard.reserve(tgt))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const grpcCheckTimeout = 5 * time.Second
+
+func init() {
+	registerChecker("grpc", func(cfg checkConfig) (Checker, error) {
+		timeout := cfg.timeout
+		if timeout == 0 {
+			timeout = grpcCheckTimeout
+		}
+
+		return grpcChecker{service: cfg.grpcService, timeout: timeout}, nil
+	})
+}
+
+// grpcChecker calls the standard grpc.health.v1.Health/Check of a target,
+// only a SERVING status is healthy.
+type grpcChecker struct {
+	service string
+	timeout time.Duration
+}
+
+func (gc grpcChecker) check(t target) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gc.timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, t.URL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: gc.service})
+	if err != nil {
+		return err
+	}
+
+	if response.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("unexpected status: %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func startGRPCHealthServer(t *testing.T) (*health.Server, string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	healthServer := health.NewServer()
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	go server.Serve(listener)
+
+	return healthServer, listener.Addr().String(), server.Stop
+}
+
+func TestNewGRPCChecker(t *testing.T) {
+	checker, err := newChecker("grpc", checkConfig{grpcService: "orders"})
+	require.NoError(t, err, "should not have failed to create grpc checker")
+	assert.Equal(t, grpcChecker{service: "orders", timeout: grpcCheckTimeout}, checker)
+
+	checker, err = newChecker("grpc", checkConfig{timeout: time.Second})
+	require.NoError(t, err, "should not have failed to create grpc checker")
+	assert.Equal(t, grpcChecker{timeout: time.Second}, checker)
+}
+
+func TestGRPCCheckerServing(t *testing.T) {
+	healthServer, addr, stop := startGRPCHealthServer(t)
+	defer stop()
+
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+
+	gc := grpcChecker{timeout: time.Second}
+	assert.NoError(t, gc.check(target{URL: addr}), "should be healthy when the server is serving")
+
+	gc.service = "orders"
+	assert.NoError(t, gc.check(target{URL: addr}), "should be healthy when the service is serving")
+}
+
+func TestGRPCCheckerNotServing(t *testing.T) {
+	healthServer, addr, stop := startGRPCHealthServer(t)
+	defer stop()
+
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	gc := grpcChecker{service: "orders", timeout: time.Second}
+	assert.Error(t, gc.check(target{URL: addr}), "should be unhealthy when the service is not serving")
+
+	gc.service = "payments"
+	assert.Error(t, gc.check(target{URL: addr}), "should be unhealthy when the service is unknown")
+}
+
+func TestGRPCCheckerUnreachable(t *testing.T) {
+	_, addr, stop := startGRPCHealthServer(t)
+	stop()
+
+	assert.Error(t, grpcChecker{timeout: time.Second}.check(target{URL: addr}))
+}
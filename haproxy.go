@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// admin states of a server in maintenance, as reported by show servers state
+const haproxyMaintStates = 0x01 | 0x02 | 0x04 | 0x20 | 0x40
+
+var haproxyServerColumns = []string{"srv_name", "srv_addr", "srv_uweight", "srv_admin_state"}
+
+// haproxyClient is a Client for the runtime api of haproxy, backends are
+// upstreams and their servers are targets.
+type haproxyClient struct {
+	network string
+	address string
+	timeout time.Duration
+	// maint marks servers with state maint and ready instead of changing
+	// their weight
+	maint bool
+
+	servers *targetIDs
+}
+
+// newHAProxyClient connects to a stats socket at a unix socket path, or at a
+// host:port prefixed with tcp://
+func newHAProxyClient(socket string, timeout time.Duration, maint bool) *haproxyClient {
+	if timeout == 0 {
+		timeout = 1000 * time.Millisecond
+	}
+
+	network, address := "unix", strings.TrimPrefix(socket, "unix://")
+	if strings.HasPrefix(socket, "tcp://") {
+		network, address = "tcp", strings.TrimPrefix(socket, "tcp://")
+	}
+
+	return &haproxyClient{
+		network: network,
+		address: address,
+		timeout: timeout,
+		maint:   maint,
+		servers: newTargetIDs(),
+	}
+}
+
+func (hc *haproxyClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+
+	output, err := hc.command("show backend")
+	if err != nil {
+		return upstreams, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+
+		upstreams = append(upstreams, upstream{ID: name, Name: name})
+	}
+
+	return upstreams, nil
+}
+
+// targetsFor lists the servers of a backend. With maint, servers in
+// maintenance are reported with a weight of 0.
+func (hc *haproxyClient) targetsFor(backend string) ([]target, error) {
+	targets := []target{}
+
+	output, err := hc.command(fmt.Sprintf("show servers state %s", backend))
+	if err != nil {
+		return targets, err
+	}
+
+	columns := map[string]int{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "# ") {
+			for i, column := range strings.Fields(strings.TrimPrefix(line, "# ")) {
+				columns[column] = i
+			}
+
+			for _, column := range haproxyServerColumns {
+				if _, ok := columns[column]; !ok {
+					return []target{}, fmt.Errorf("servers state of backend %s has no %s", backend, column)
+				}
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(columns) == 0 || len(fields) < len(columns) {
+			continue
+		}
+
+		t, err := hc.targetFrom(backend, columns, fields)
+		if err != nil {
+			return []target{}, err
+		}
+
+		targets = append(targets, t)
+	}
+
+	if len(columns) == 0 {
+		return []target{}, fmt.Errorf("failed to show servers of backend %s: %s", backend, strings.TrimSpace(output))
+	}
+
+	return targets, nil
+}
+
+func (hc *haproxyClient) targetFrom(backend string, columns map[string]int, fields []string) (target, error) {
+	weight, err := strconv.Atoi(fields[columns["srv_uweight"]])
+	if err != nil {
+		return target{}, fmt.Errorf("invalid weight of server %s: %s", fields[columns["srv_name"]], err)
+	}
+
+	adminState, err := strconv.Atoi(fields[columns["srv_admin_state"]])
+	if err != nil {
+		return target{}, fmt.Errorf("invalid admin state of server %s: %s", fields[columns["srv_name"]], err)
+	}
+
+	if hc.maint && adminState&haproxyMaintStates != 0 {
+		weight = unhealthyNodeWeight
+	}
+
+	t := target{
+		ID:         fields[columns["srv_name"]],
+		URL:        fields[columns["srv_addr"]],
+		Weight:     weight,
+		UpstreamID: backend,
+	}
+
+	// haproxy before 1.8 does not report the port of servers
+	if i, ok := columns["srv_port"]; ok {
+		t.URL = net.JoinHostPort(t.URL, fields[i])
+	}
+
+	hc.servers.set(t, t.ID)
+
+	return t, nil
+}
+
+// setTargetWeightFor sets the weight of the server at targetURL, or with
+// maint puts it into maintenance for a weight of 0 and makes it ready
+// otherwise.
+func (hc *haproxyClient) setTargetWeightFor(backend, targetURL string, weight int) error {
+	server, err := hc.servers.lookup(backend, targetURL, hc.targetsFor)
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("set server %s/%s weight %d", backend, server, weight)
+	if hc.maint {
+		state := "ready"
+		if weight <= unhealthyNodeWeight {
+			state = "maint"
+		}
+		cmd = fmt.Sprintf("set server %s/%s state %s", backend, server, state)
+	}
+
+	output, err := hc.command(cmd)
+	if err != nil {
+		return err
+	}
+
+	if output = strings.TrimSpace(output); output != "" {
+		return fmt.Errorf("failed to %s: %s", cmd, output)
+	}
+
+	return nil
+}
+
+// command sends a command to the stats socket, which answers and closes the
+// connection as it is not in interactive mode
+func (hc *haproxyClient) command(cmd string) (string, error) {
+	conn, err := net.DialTimeout(hc.network, hc.address, hc.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(hc.timeout))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = fmt.Fprintf(conn, "%s\n", cmd)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	return string(output), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServersState = `1
+# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord
+3 app 1 web1 10.0.0.1 2 0 100 100 4 6 3 4 6 0 0 0 - 8080 -
+3 app 2 web2 10.0.0.2 0 1 50 50 4 6 3 4 6 0 0 0 - 8080 -
+`
+
+// fakeStatsSocket answers the commands of the haproxy runtime api from
+// responses, every command received is sent on the returned channel
+func fakeStatsSocket(listener net.Listener, responses map[string]string) chan string {
+	commands := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			cmd, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				conn.Close()
+				continue
+			}
+
+			cmd = strings.TrimSpace(cmd)
+			commands <- cmd
+
+			response, ok := responses[cmd]
+			if !ok {
+				response = "Unknown command.\n"
+			}
+
+			conn.Write([]byte(response))
+			conn.Close()
+		}
+	}()
+
+	return commands
+}
+
+func newTestHAProxyClient(t *testing.T, maint bool, responses map[string]string) (*haproxyClient, chan string, func()) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+
+	socket := filepath.Join(dir, "haproxy.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	commands := fakeStatsSocket(listener, responses)
+
+	return newHAProxyClient(socket, time.Second, maint), commands, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHAProxyClientListsBackendsAsUpstreams(t *testing.T) {
+	hc, _, cleanup := newTestHAProxyClient(t, false, map[string]string{
+		"show backend": "# name\nstats\napp\n",
+	})
+	defer cleanup()
+
+	upstreams, err := hc.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	assert.Equal(t, []upstream{{ID: "stats", Name: "stats"}, {ID: "app", Name: "app"}}, upstreams)
+}
+
+func TestHAProxyClientListsServersAsTargets(t *testing.T) {
+	hc, _, cleanup := newTestHAProxyClient(t, false, map[string]string{
+		"show servers state app": testServersState,
+	})
+	defer cleanup()
+
+	targets, err := hc.targetsFor("app")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	assert.Equal(t, []target{
+		{ID: "web1", URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "app"},
+		{ID: "web2", URL: "10.0.0.2:8080", Weight: 50, UpstreamID: "app"},
+	}, targets)
+}
+
+func TestHAProxyClientReportsServersInMaintenanceAsUnhealthy(t *testing.T) {
+	hc, _, cleanup := newTestHAProxyClient(t, true, map[string]string{
+		"show servers state app": testServersState,
+	})
+	defer cleanup()
+
+	targets, err := hc.targetsFor("app")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 2, len(targets))
+	assert.Equal(t, 100, targets[0].Weight)
+	assert.Equal(t, 0, targets[1].Weight)
+}
+
+func TestHAProxyClientFailsOnUnknownBackend(t *testing.T) {
+	hc, _, cleanup := newTestHAProxyClient(t, false, map[string]string{
+		"show servers state nope": "Can't find backend.\n",
+	})
+	defer cleanup()
+
+	targets, err := hc.targetsFor("nope")
+	require.Error(t, err, "should have failed to get targets of an unknown backend")
+	assert.Equal(t, 0, len(targets))
+}
+
+func TestHAProxyClientSetsServerWeight(t *testing.T) {
+	hc, commands, cleanup := newTestHAProxyClient(t, false, map[string]string{
+		"show servers state app":        testServersState,
+		"set server app/web2 weight 0":  "",
+		"set server app/web2 weight 50": "",
+	})
+	defer cleanup()
+
+	err := hc.setTargetWeightFor("app", "10.0.0.2:8080", 0)
+	require.NoError(t, err, "should not have failed to set target weight")
+	assert.Equal(t, "show servers state app", <-commands, "should have looked up the name of the server")
+	assert.Equal(t, "set server app/web2 weight 0", <-commands)
+
+	err = hc.setTargetWeightFor("app", "10.0.0.2:8080", 50)
+	require.NoError(t, err, "should not have failed to set target weight")
+	assert.Equal(t, "set server app/web2 weight 50", <-commands)
+}
+
+func TestHAProxyClientSetsServerState(t *testing.T) {
+	hc, commands, cleanup := newTestHAProxyClient(t, true, map[string]string{
+		"show servers state app":          testServersState,
+		"set server app/web1 state maint": "",
+		"set server app/web1 state ready": "",
+	})
+	defer cleanup()
+
+	_, err := hc.targetsFor("app")
+	require.NoError(t, err)
+	<-commands
+
+	require.NoError(t, hc.setTargetWeightFor("app", "10.0.0.1:8080", 0))
+	assert.Equal(t, "set server app/web1 state maint", <-commands)
+
+	require.NoError(t, hc.setTargetWeightFor("app", "10.0.0.1:8080", 100))
+	assert.Equal(t, "set server app/web1 state ready", <-commands)
+}
+
+func TestHAProxyClientSetTargetWeightFailures(t *testing.T) {
+	hc, _, cleanup := newTestHAProxyClient(t, false, map[string]string{
+		"show servers state app": testServersState,
+	})
+	defer cleanup()
+
+	err := hc.setTargetWeightFor("app", "10.0.0.9:8080", 0)
+	assert.Error(t, err, "should have failed to set weight of an unknown server")
+
+	err = hc.setTargetWeightFor("app", "10.0.0.1:8080", 0)
+	assert.Error(t, err, "should have failed when haproxy answers with an error")
+}
+
+func TestHAProxyClientOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	fakeStatsSocket(listener, map[string]string{"show backend": "# name\napp\n"})
+
+	hc := newHAProxyClient("tcp://"+listener.Addr().String(), time.Second, false)
+	upstreams, err := hc.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+	assert.Equal(t, 1, len(upstreams))
+}
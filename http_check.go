@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gojektech/heimdall/httpclient"
+)
+
+const maxCheckBodySize = 64 * 1024
+const httpsCheckTimeout = 30 * time.Second
+
+func init() {
+	registerChecker("http", func(cfg checkConfig) (Checker, error) {
+		client := httpclient.NewClient()
+		if cfg.timeout > 0 {
+			client = httpclient.NewClient(httpclient.WithHTTPTimeout(cfg.timeout))
+		}
+
+		return httpChecker{client: client, scheme: "http", path: cfg.path, expectation: cfg.http}, nil
+	})
+
+	registerChecker("https", func(cfg checkConfig) (Checker, error) {
+		tlsConfig, err := newTLSConfig(cfg.tls)
+		if err != nil {
+			return nil, err
+		}
+
+		timeout := cfg.timeout
+		if timeout == 0 {
+			timeout = httpsCheckTimeout
+		}
+
+		client := &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+
+		return httpChecker{client: client, scheme: "https", path: cfg.path, expectation: cfg.http}, nil
+	})
+}
+
+// httpDoer is satisfied by both heimdall and net/http clients
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// httpChecker checks a target by sending a request to path and matching the
+// response against an expectation
+type httpChecker struct {
+	client      httpDoer
+	scheme      string
+	path        string
+	expectation httpExpectation
+}
+
+func (hc httpChecker) check(t target) error {
+	req, err := http.NewRequest(hc.expectation.requestMethod(), hc.checkURL(t), nil)
+	if err != nil {
+		return err
+	}
+
+	hc.expectation.applyTo(req)
+
+	response, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if !hc.expectation.acceptsStatus(response.StatusCode) {
+		return fmt.Errorf("unexpected status: %d", response.StatusCode)
+	}
+
+	if hc.expectation.body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxCheckBodySize))
+	if err != nil {
+		return err
+	}
+
+	if !hc.expectation.body.Match(body) {
+		return fmt.Errorf("response body did not match %q", hc.expectation.body)
+	}
+
+	return nil
+}
+
+// checkURL builds the url to check from the host:port of a target, using the
+// scheme of the checker.
+func (hc httpChecker) checkURL(t target) string {
+	if strings.Contains(t.URL, "://") {
+		return fmt.Sprintf("%s%s", t.URL, hc.path)
+	}
+
+	return fmt.Sprintf("%s://%s%s", hc.scheme, t.URL, hc.path)
+}
+
+// httpExpectation describes the request sent by an http check and the
+// response expected from a healthy target. The zero value sends a GET and
+// accepts any status below 500.
+type httpExpectation struct {
+	method   string
+	headers  http.Header
+	statuses statusRanges
+	body     *regexp.Regexp
+}
+
+func newHTTPExpectation(method string, headers http.Header, statuses, body string) (httpExpectation, error) {
+	expectation := httpExpectation{method: strings.ToUpper(method), headers: headers}
+
+	ranges, err := parseStatusRanges(statuses)
+	if err != nil {
+		return expectation, err
+	}
+	expectation.statuses = ranges
+
+	if body != "" {
+		expectation.body, err = regexp.Compile(body)
+		if err != nil {
+			return expectation, fmt.Errorf("invalid body expectation %q: %s", body, err)
+		}
+	}
+
+	return expectation, nil
+}
+
+func (he httpExpectation) requestMethod() string {
+	if he.method == "" {
+		return http.MethodGet
+	}
+
+	return he.method
+}
+
+func (he httpExpectation) applyTo(req *http.Request) {
+	for name, values := range he.headers {
+		if http.CanonicalHeaderKey(name) == "Host" && len(values) > 0 {
+			req.Host = values[0]
+			continue
+		}
+
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
+
+func (he httpExpectation) acceptsStatus(status int) bool {
+	if len(he.statuses) == 0 {
+		return status < http.StatusInternalServerError
+	}
+
+	return he.statuses.contains(status)
+}
+
+type statusRange struct {
+	from int
+	to   int
+}
+
+// statusRanges is a list of accepted status codes, parsed from a comma
+// separated list of codes and ranges like "200-299,301".
+type statusRanges []statusRange
+
+func parseStatusRanges(statuses string) (statusRanges, error) {
+	ranges := statusRanges{}
+	if statuses == "" {
+		return ranges, nil
+	}
+
+	for _, status := range strings.Split(statuses, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(status), "-", 2)
+
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q: %s", status, err)
+		}
+
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid status %q: %s", status, err)
+			}
+		}
+
+		if from > to {
+			return nil, fmt.Errorf("invalid status range %q", status)
+		}
+
+		ranges = append(ranges, statusRange{from: from, to: to})
+	}
+
+	return ranges, nil
+}
+
+func (sr statusRanges) contains(status int) bool {
+	for _, r := range sr {
+		if status >= r.from && status <= r.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerFlags collects repeated "Name: value" flags into http.Header
+type headerFlags http.Header
+
+func (hf headerFlags) String() string {
+	headers := []string{}
+	for name, values := range hf {
+		for _, value := range values {
+			headers = append(headers, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+
+	return strings.Join(headers, ", ")
+}
+
+func (hf headerFlags) Set(header string) error {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("invalid header %q, expected Name: value", header)
+	}
+
+	http.Header(hf).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPCheckers(t *testing.T) {
+	checker, err := newChecker("http", checkConfig{path: "/ping"})
+	require.NoError(t, err, "should not have failed to create http checker")
+	assert.Equal(t, "http", checker.(httpChecker).scheme)
+	assert.Equal(t, "/ping", checker.(httpChecker).path)
+
+	checker, err = newChecker("https", checkConfig{path: "/ping", tls: tlsOptions{insecure: true}})
+	require.NoError(t, err, "should not have failed to create https checker")
+	assert.Equal(t, "https", checker.(httpChecker).scheme)
+
+	_, err = newChecker("https", checkConfig{tls: tlsOptions{caFile: "missing.crt"}})
+	assert.Error(t, err, "should have failed to create https checker")
+}
+
+func TestNewHTTPExpectation(t *testing.T) {
+	headers := http.Header{"X-Probe": []string{"probed"}}
+
+	expectation, err := newHTTPExpectation("head", headers, "200-299,404", "^ok")
+	require.NoError(t, err, "should not have failed to create http expectation")
+
+	assert.Equal(t, http.MethodHead, expectation.requestMethod())
+	assert.Equal(t, headers, expectation.headers)
+	assert.Equal(t, statusRanges{{from: 200, to: 299}, {from: 404, to: 404}}, expectation.statuses)
+	assert.Equal(t, "^ok", expectation.body.String())
+}
+
+func TestNewHTTPExpectationFailure(t *testing.T) {
+	_, err := newHTTPExpectation("GET", nil, "200-abc", "")
+	assert.Error(t, err, "should have failed to parse statuses")
+
+	_, err = newHTTPExpectation("GET", nil, "", "(ok")
+	assert.Error(t, err, "should have failed to parse body regex")
+}
+
+func TestHTTPExpectationDefaults(t *testing.T) {
+	expectation := httpExpectation{}
+
+	assert.Equal(t, http.MethodGet, expectation.requestMethod())
+	assert.True(t, expectation.acceptsStatus(http.StatusOK))
+	assert.True(t, expectation.acceptsStatus(http.StatusNotFound))
+	assert.False(t, expectation.acceptsStatus(http.StatusBadGateway))
+}
+
+func TestHTTPExpectationAppliesHeadersAndHost(t *testing.T) {
+	expectation := httpExpectation{headers: http.Header{"Host": []string{"api.example.com"}, "X-Probe": []string{"probed"}}}
+
+	req, err := http.NewRequest(http.MethodGet, "http://1.2.3.4:80/ping", nil)
+	require.NoError(t, err)
+
+	expectation.applyTo(req)
+
+	assert.Equal(t, "api.example.com", req.Host)
+	assert.Equal(t, "probed", req.Header.Get("X-Probe"))
+	assert.Empty(t, req.Header.Get("Host"))
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	ranges, err := parseStatusRanges("200-299, 301,418")
+	require.NoError(t, err, "should not have failed to parse statuses")
+
+	assert.True(t, ranges.contains(200))
+	assert.True(t, ranges.contains(299))
+	assert.True(t, ranges.contains(301))
+	assert.True(t, ranges.contains(418))
+	assert.False(t, ranges.contains(302))
+	assert.False(t, ranges.contains(500))
+}
+
+func TestParseStatusRangesFailure(t *testing.T) {
+	for _, statuses := range []string{"abc", "200-", "299-200", "200,,300"} {
+		_, err := parseStatusRanges(statuses)
+		assert.Error(t, err, "should have failed to parse %s", statuses)
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	headers := headerFlags{}
+
+	require.NoError(t, headers.Set("Host: api.example.com"))
+	require.NoError(t, headers.Set("X-Probe:probed"))
+	require.NoError(t, headers.Set("X-Probe: again"))
+
+	assert.Equal(t, []string{"api.example.com"}, http.Header(headers)["Host"])
+	assert.Equal(t, []string{"probed", "again"}, http.Header(headers)["X-Probe"])
+
+	assert.Error(t, headers.Set("X-Probe"))
+	assert.Error(t, headers.Set(": probed"))
+}
+
+func TestHTTPCheckerWithExpectations(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		assert.Equal(t, "/ping", r.URL.Path)
+		assert.Equal(t, "api.example.com", r.Host)
+		assert.Equal(t, "probed", r.Header.Get("X-Probe"))
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer svr.Close()
+
+	expectation, err := newHTTPExpectation("HEAD", http.Header{"Host": []string{"api.example.com"}, "X-Probe": []string{"probed"}}, "204", "")
+	require.NoError(t, err)
+
+	hc := httpChecker{client: HTTPClient, scheme: "http", path: "/ping", expectation: expectation}
+	assert.NoError(t, hc.check(target{URL: svr.URL}))
+}
+
+func TestHTTPCheckerFailsOnUnexpectedStatus(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer svr.Close()
+
+	hc := httpChecker{client: HTTPClient, scheme: "http", path: "/ping"}
+	assert.NoError(t, hc.check(target{URL: svr.URL}), "should accept any status below 500 by default")
+
+	expectation, err := newHTTPExpectation("GET", nil, "200-299", "")
+	require.NoError(t, err)
+
+	hc.expectation = expectation
+	assert.Error(t, hc.check(target{URL: svr.URL}))
+}
+
+func TestHTTPCheckerMatchesResponseBody(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "degraded"}`))
+	}))
+	defer svr.Close()
+
+	expectation, err := newHTTPExpectation("GET", nil, "", `"status":\s*"degraded"`)
+	require.NoError(t, err)
+
+	hc := httpChecker{client: HTTPClient, scheme: "http", path: "/ping", expectation: expectation}
+	assert.NoError(t, hc.check(target{URL: svr.URL}))
+
+	hc.expectation, err = newHTTPExpectation("GET", nil, "", `"status":\s*"ok"`)
+	require.NoError(t, err)
+	assert.Error(t, hc.check(target{URL: svr.URL}))
+}
+
+func TestHTTPSCheckerVerifiesTargetsAndPresentsClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir)
+	clientCAs, err := newTLSConfig(tlsOptions{caFile: certFile})
+	require.NoError(t, err)
+
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "example.com", r.TLS.ServerName)
+		assert.Equal(t, 1, len(r.TLS.PeerCertificates))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	svr.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs.RootCAs}
+	svr.StartTLS()
+	defer svr.Close()
+
+	serverCA := filepath.Join(dir, "server.crt")
+	require.NoError(t, ioutil.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw}), 0600))
+
+	tlsConfig, err := newTLSConfig(tlsOptions{serverName: "example.com", caFile: serverCA, certFile: certFile, keyFile: keyFile})
+	require.NoError(t, err)
+
+	pingClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	tgt := target{URL: strings.TrimPrefix(svr.URL, "https://")}
+
+	hc := httpChecker{client: pingClient, scheme: "https", path: "/ping"}
+	assert.NoError(t, hc.check(tgt))
+
+	tlsConfig, err = newTLSConfig(tlsOptions{serverName: "example.com", caFile: serverCA})
+	require.NoError(t, err)
+
+	hc.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	assert.Error(t, hc.check(tgt), "should have failed without a client certificate")
+}
+
+func TestHTTPSCheckerSkipsVerification(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	tgt := target{URL: strings.TrimPrefix(svr.URL, "https://")}
+
+	hc := httpChecker{client: &http.Client{}, scheme: "https", path: "/ping"}
+	assert.Error(t, hc.check(tgt), "should have failed to verify the target")
+
+	tlsConfig, err := newTLSConfig(tlsOptions{insecure: true})
+	require.NoError(t, err)
+
+	hc.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	assert.NoError(t, hc.check(tgt))
+}
+
+func TestHTTPCheckerURLAddsSchemeOfChecker(t *testing.T) {
+	hc := httpChecker{scheme: "http", path: "/ping"}
+	assert.Equal(t, "http://1.2.3.4:80/ping", hc.checkURL(target{URL: "1.2.3.4:80"}))
+	assert.Equal(t, "http://127.0.0.1:8080/ping", hc.checkURL(target{URL: "http://127.0.0.1:8080"}))
+
+	hc.scheme = "https"
+	assert.Equal(t, "https://1.2.3.4:443/ping", hc.checkURL(target{URL: "1.2.3.4:443"}))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// doJSONRequest sends a request with a json body to the http api of a load
+// balancer and returns the body of the response, responses with a status of
+// 400 or above fail with a statusError. prepare, when set, adds headers like
+// credentials to the request.
+func doJSONRequest(httpClient httpDoer, method, requestURL string, body []byte, prepare func(req *http.Request)) ([]byte, error) {
+	var respBytes []byte
+
+	req, err := http.NewRequest(method, requestURL, bytes.NewBuffer(body))
+	if err != nil {
+		return respBytes, fmt.Errorf("failed to create request: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if prepare != nil {
+		prepare(req)
+	}
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return respBytes, err
+	}
+
+	defer response.Body.Close()
+
+	respBytes, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return respBytes, err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return respBytes, statusError{status: response.StatusCode}
+	}
+
+	return respBytes, nil
+}
@@ -1,95 +1,392 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gojektech/heimdall/httpclient"
 )
 
 type kongClient struct {
-	httpClient   *httpclient.Client
+	httpClient   httpDoer
 	kongAdminURL string
+	pageSize     int
+	auth         kongAuth
+
+	// nodes fails over between the admin apis of several kong nodes, only
+	// kongAdminURL is used when nil
+	nodes *kongNodes
+
+	// healthEndpoints marks targets through the healthy and unhealthy
+	// endpoints of kong 0.12+ instead of changing their weight
+	healthEndpoints bool
+
+	// tags only lists upstreams tagged in kong 1.1+, "a,b" lists upstreams
+	// with both tags and "a/b" upstreams with either
+	tags string
+
+	// workspaces of kong enterprise to check the upstreams of, every
+	// workspace is discovered when discoverWorkspaces is set
+	workspaces         []string
+	discoverWorkspaces bool
+
+	versionMu sync.Mutex
+	version   *kongVersion
+
+	workspaceMu        sync.Mutex
+	upstreamWorkspaces map[string]string
+}
+
+type kongClientOption func(kc *kongClient)
+
+// withPageSize sets the no of entities fetched per page of kong list
+// endpoints, kong's default is used when 0
+func withPageSize(pageSize int) kongClientOption {
+	return func(kc *kongClient) {
+		kc.pageSize = pageSize
+	}
 }
 
-func newKongClient(kongHost, kongAdminPort string, timeout time.Duration) *kongClient {
+// withHealthEndpoints marks targets healthy or unhealthy in kong's balancer
+// without changing their configured weight, falling back to weights on kong
+// before 0.12
+func withHealthEndpoints() kongClientOption {
+	return func(kc *kongClient) {
+		kc.healthEndpoints = true
+	}
+}
+
+// withTags only checks upstreams with the tags, as filtered by kong 1.1+
+func withTags(tags string) kongClientOption {
+	return func(kc *kongClient) {
+		kc.tags = tags
+	}
+}
+
+func newKongClient(kongHost, kongAdminPort string, timeout time.Duration, opts ...kongClientOption) *kongClient {
 	if timeout == 0 {
 		timeout = 1000 * time.Millisecond
 	}
-	return &kongClient{
+	kc := &kongClient{
 		kongAdminURL: fmt.Sprintf("%s:%s", kongHost, kongAdminPort),
 		httpClient:   httpclient.NewClient(httpclient.WithHTTPTimeout(timeout)),
 	}
+
+	urls := kongAdminURLs(kongHost, kongAdminPort)
+	if len(urls) > 0 {
+		kc.kongAdminURL = urls[0]
+	}
+	if len(urls) > 1 {
+		kc.nodes = newKongNodes(urls)
+	}
+
+	for _, opt := range opts {
+		opt(kc)
+	}
+
+	if kc.auth.tlsConfig != nil {
+		kc.httpClient = &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: kc.auth.tlsConfig},
+		}
+	}
+
+	return kc
 }
 
 type upstreamResponse struct {
-	Data []upstream `json:"data"`
+	Data   []upstream `json:"data"`
+	Offset string     `json:"offset"`
 }
 
 type upstream struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Healthchecks *kongHealthchecks `json:"healthchecks,omitempty"`
+
+	// Workspace is the kong enterprise workspace of the upstream, empty for
+	// the default workspace
+	Workspace string `json:"-"`
+}
+
+// kongHealthchecks is the healthchecks object of kong 0.12+ upstreams, only
+// the active checks are used by probed
+type kongHealthchecks struct {
+	Active *kongActiveHealthcheck `json:"active"`
+}
+
+type kongActiveHealthcheck struct {
+	Type                   string  `json:"type"`
+	Timeout                float64 `json:"timeout"`
+	HTTPPath               string  `json:"http_path"`
+	HTTPSSNI               string  `json:"https_sni"`
+	HTTPSVerifyCertificate *bool   `json:"https_verify_certificate"`
+	Healthy                struct {
+		Interval     float64 `json:"interval"`
+		HTTPStatuses []int   `json:"http_statuses"`
+		Successes    int     `json:"successes"`
+	} `json:"healthy"`
+	Unhealthy struct {
+		Interval     float64 `json:"interval"`
+		HTTPFailures int     `json:"http_failures"`
+		TCPFailures  int     `json:"tcp_failures"`
+	} `json:"unhealthy"`
+}
+
+// isKongDefault reports whether the active health check is the one kong fills
+// in for every upstream, which nobody configured: an http check of / which
+// kong itself never runs, as both of its intervals are 0.
+func (ah kongActiveHealthcheck) isKongDefault() bool {
+	if ah.Type != "" && ah.Type != "http" {
+		return false
+	}
+	if ah.HTTPPath != "" && ah.HTTPPath != "/" {
+		return false
+	}
+	if ah.Timeout != 0 && ah.Timeout != 1 {
+		return false
+	}
+	if ah.HTTPSSNI != "" || (ah.HTTPSVerifyCertificate != nil && !*ah.HTTPSVerifyCertificate) {
+		return false
+	}
+	if ah.Healthy.Interval != 0 || ah.Healthy.Successes != 0 || ah.Unhealthy.Interval != 0 {
+		return false
+	}
+	if ah.Unhealthy.HTTPFailures != 0 || ah.Unhealthy.TCPFailures != 0 {
+		return false
+	}
+
+	statuses := ah.Healthy.HTTPStatuses
+	return len(statuses) == 0 || reflect.DeepEqual(statuses, []int{200, 302})
+}
+
+// overrides applies the active health check of a kong upstream on top of the
+// defaults, kong before 1.0 only supports http checks. Values kong reports as
+// 0 are disabled there, and fall back to the defaults.
+func (ah kongActiveHealthcheck) overrides(defaults checkDefaults) checkDefaults {
+	defaults.checkType = ah.Type
+	if defaults.checkType == "" {
+		defaults.checkType = "http"
+	}
+
+	if ah.HTTPPath != "" {
+		defaults.check.path = ah.HTTPPath
+	}
+	if ah.Timeout > 0 {
+		defaults.check.timeout = secondsToDuration(ah.Timeout)
+	}
+	if ah.Healthy.Interval > 0 {
+		defaults.interval = secondsToDuration(ah.Healthy.Interval)
+	}
+	if ah.Healthy.Successes > 0 {
+		defaults.rise = ah.Healthy.Successes
+	}
+
+	fall := ah.Unhealthy.HTTPFailures
+	if defaults.checkType == "tcp" {
+		fall = ah.Unhealthy.TCPFailures
+	}
+	if fall > 0 {
+		defaults.fall = fall
+	}
+
+	if len(ah.Healthy.HTTPStatuses) > 0 {
+		statuses := statusRanges{}
+		for _, status := range ah.Healthy.HTTPStatuses {
+			statuses = append(statuses, statusRange{from: status, to: status})
+		}
+		defaults.check.http.statuses = statuses
+	}
+
+	if ah.HTTPSSNI != "" {
+		defaults.check.tls.serverName = ah.HTTPSSNI
+	}
+	if ah.HTTPSVerifyCertificate != nil {
+		defaults.check.tls.insecure = !*ah.HTTPSVerifyCertificate
+	}
+
+	return defaults
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
 }
 
 func (kc *kongClient) upstreams() ([]upstream, error) {
 	upstreams := []upstream{}
 
-	respBytes, err := kc.doRequest(http.MethodGet, "upstreams", nil)
+	workspaces, err := kc.listWorkspaces()
 	if err != nil {
 		return upstreams, err
 	}
 
-	upstreamResponse := &upstreamResponse{}
-
-	err = json.Unmarshal(respBytes, upstreamResponse)
+	filter, err := kc.upstreamFilter()
 	if err != nil {
 		return upstreams, err
 	}
 
-	return upstreamResponse.Data, nil
+	for _, workspace := range workspaces {
+		err = kc.paginate(workspacePath(workspace, "upstreams"), filter, func(respBytes []byte) (string, error) {
+			upstreamResponse := &upstreamResponse{}
+
+			err := json.Unmarshal(respBytes, upstreamResponse)
+			if err != nil {
+				return "", err
+			}
+
+			for _, u := range upstreamResponse.Data {
+				u.Workspace = workspace
+				upstreams = append(upstreams, u)
+			}
+			return upstreamResponse.Offset, nil
+		})
+		if err != nil {
+			return []upstream{}, err
+		}
+	}
+
+	kc.rememberWorkspaces(upstreams)
+	return upstreams, nil
 }
 
+// unhealthyTargetHealth is the health kong reports for targets its balancer
+// does not route to
+const unhealthyTargetHealth = "UNHEALTHY"
+
 type target struct {
 	ID         string `json:"id,omitempty"`
 	URL        string `json:"target"`
 	Weight     int    `json:"weight"`
 	UpstreamID string `json:"upstream_id,omitempty"`
+	Health     string `json:"health,omitempty"`
+
+	// Workspace is the kong enterprise workspace of the upstream of the
+	// target, empty for the default workspace
+	Workspace string `json:"-"`
+
+	// CreatedAt orders the history kong keeps for a target address, it is in
+	// milliseconds before kong 1.0 and in seconds after
+	CreatedAt float64 `json:"created_at,omitempty"`
+
+	// policy is how the target is checked, resolved from its upstream
+	policy *checkPolicy
+}
+
+// UnmarshalJSON decodes targets of every kong version, kong 1.0+ nests the id
+// of the upstream of a target instead of reporting upstream_id.
+func (t *target) UnmarshalJSON(targetBytes []byte) error {
+	type kongTarget target
+
+	decoded := struct {
+		*kongTarget
+		Upstream *struct {
+			ID string `json:"id"`
+		} `json:"upstream"`
+	}{kongTarget: (*kongTarget)(t)}
+
+	err := json.Unmarshal(targetBytes, &decoded)
+	if err != nil {
+		return err
+	}
+
+	if t.UpstreamID == "" && decoded.Upstream != nil {
+		t.UpstreamID = decoded.Upstream.ID
+	}
+
+	return nil
 }
 
 type targetResponse struct {
-	Data []target `json:"data"`
+	Data   []target `json:"data"`
+	Offset string   `json:"offset"`
 }
 
+// targetsFor lists the targets of an upstream. When marking health through
+// kong's endpoints, the targets are read from the health of the upstream and
+// the ones kong considers unhealthy are reported with a weight of 0.
 func (kc *kongClient) targetsFor(upstreamID string) ([]target, error) {
 	targets := []target{}
 
-	respBytes, err := kc.doRequest(http.MethodGet, fmt.Sprintf("upstreams/%s/targets", upstreamID), nil)
+	useHealthEndpoints, err := kc.usesHealthEndpoints()
 	if err != nil {
 		return targets, err
 	}
 
-	targetResponse := &targetResponse{}
+	path := kc.upstreamPath(upstreamID, "targets")
+	if useHealthEndpoints {
+		path = kc.upstreamPath(upstreamID, "health")
+	}
+
+	err = kc.paginate(path, nil, func(respBytes []byte) (string, error) {
+		targetResponse := &targetResponse{}
 
-	err = json.Unmarshal(respBytes, targetResponse)
+		err := json.Unmarshal(respBytes, targetResponse)
+		if err != nil {
+			return "", err
+		}
+
+		targets = append(targets, targetResponse.Data...)
+		return targetResponse.Offset, nil
+	})
 	if err != nil {
-		return targets, err
+		return []target{}, err
 	}
 
-	return targetResponse.Data, nil
+	workspace := kc.workspaceOf(upstreamID)
+	for i := range targets {
+		targets[i].Workspace = workspace
+	}
+
+	if useHealthEndpoints {
+		for i := range targets {
+			if targets[i].Health == unhealthyTargetHealth {
+				targets[i].Weight = unhealthyNodeWeight
+			}
+		}
+	}
+
+	return targets, nil
 }
 
+// setTargetWeightFor updates the weight of a target in place on kong 2.2+ and
+// appends it to the history of the target on older versions of kong. When
+// marking health through kong's endpoints a weight of 0 marks the target
+// unhealthy and any other weight marks it healthy.
 func (kc *kongClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	version, err := kc.detectVersion()
+	if err != nil {
+		return err
+	}
+
+	if kc.healthEndpoints && version.hasHealthEndpoints() {
+		health := "healthy"
+		if weight <= unhealthyNodeWeight {
+			health = "unhealthy"
+		}
+
+		_, err = kc.doRequest(http.MethodPost, kc.upstreamPath(upstreamID, "targets", url.PathEscape(targetURL), health), nil)
+		return err
+	}
+
 	target := target{URL: targetURL, Weight: weight}
 	requestBody, err := json.Marshal(target)
 	if err != nil {
 		return err
 	}
 
-	_, err = kc.doRequest(http.MethodPost, fmt.Sprintf("upstreams/%s/targets", upstreamID), requestBody)
+	if version.patchesTargets() {
+		_, err = kc.doRequest(http.MethodPatch, kc.upstreamPath(upstreamID, "targets", url.PathEscape(targetURL)), requestBody)
+		return err
+	}
+
+	_, err = kc.doRequest(http.MethodPost, kc.upstreamPath(upstreamID, "targets"), requestBody)
 	if err != nil {
 		return err
 	}
@@ -97,31 +394,163 @@ func (kc *kongClient) setTargetWeightFor(upstreamID, targetURL string, weight in
 	return nil
 }
 
-func (kc *kongClient) doRequest(method, path string, body []byte) ([]byte, error) {
-	var respBytes []byte
+// markUnhealthyEntry sets the weight of a target to 0 and reports the id of
+// the entry kong appended to the history of the target for it, which is empty
+// when kong changed the target in place.
+func (kc *kongClient) markUnhealthyEntry(upstreamID, targetURL string) (string, error) {
+	version, err := kc.detectVersion()
+	if err != nil {
+		return "", err
+	}
+
+	if (kc.healthEndpoints && version.hasHealthEndpoints()) || version.patchesTargets() {
+		return "", kc.setTargetWeightFor(upstreamID, targetURL, unhealthyNodeWeight)
+	}
 
-	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", kc.kongAdminURL, path), bytes.NewBuffer(body))
+	requestBody, err := json.Marshal(target{URL: targetURL, Weight: unhealthyNodeWeight})
 	if err != nil {
-		return respBytes, fmt.Errorf("failed to create request: %s", err)
+		return "", err
+	}
+
+	respBytes, err := kc.doRequest(http.MethodPost, kc.upstreamPath(upstreamID, "targets"), requestBody)
+	if err != nil || len(respBytes) == 0 {
+		return "", err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	entry := target{}
+	err = json.Unmarshal(respBytes, &entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode the entry of target %s: %s", targetURL, err)
+	}
+
+	return entry.ID, nil
+}
+
+func (kc *kongClient) usesHealthEndpoints() (bool, error) {
+	if !kc.healthEndpoints {
+		return false, nil
+	}
 
-	response, err := kc.httpClient.Do(req)
+	version, err := kc.detectVersion()
 	if err != nil {
-		return respBytes, err
+		return false, err
 	}
 
-	defer response.Body.Close()
+	return version.hasHealthEndpoints(), nil
+}
+
+// upstreamFilter filters upstreams by tags, which kong added in 1.1
+func (kc *kongClient) upstreamFilter() (url.Values, error) {
+	if kc.tags == "" {
+		return nil, nil
+	}
 
-	respBytes, err = ioutil.ReadAll(response.Body)
+	version, err := kc.detectVersion()
 	if err != nil {
-		return respBytes, err
+		return nil, err
+	}
+
+	if !version.atLeast(1, 1) {
+		return nil, fmt.Errorf("kong %s does not support filtering upstreams by tags", version)
+	}
+
+	return url.Values{"tags": []string{kc.tags}}, nil
+}
+
+// paginate fetches every page of a kong list endpoint filtered by filter,
+// page decodes a response and returns the offset of the next page, which is
+// empty on the last one.
+func (kc *kongClient) paginate(path string, filter url.Values, page func(respBytes []byte) (string, error)) error {
+	offset := ""
+
+	for {
+		query := url.Values{}
+		for name, values := range filter {
+			query[name] = values
+		}
+		if kc.pageSize > 0 {
+			query.Set("size", strconv.Itoa(kc.pageSize))
+		}
+		if offset != "" {
+			query.Set("offset", offset)
+		}
+
+		pagePath := path
+		if len(query) > 0 {
+			pagePath = fmt.Sprintf("%s?%s", path, query.Encode())
+		}
+
+		respBytes, err := kc.doRequest(http.MethodGet, pagePath, nil)
+		if err != nil {
+			return err
+		}
+
+		nextOffset, err := page(respBytes)
+		if err != nil {
+			return err
+		}
+
+		if nextOffset == "" {
+			return nil
+		}
+
+		if nextOffset == offset {
+			return fmt.Errorf("kong returned the same offset %s for %s twice", offset, path)
+		}
+
+		offset = nextOffset
 	}
+}
+
+// statusError is returned for error responses of the admin api
+type statusError struct {
+	status int
+}
+
+func (se statusError) Error() string {
+	return fmt.Sprintf("failed with status: %d", se.status)
+}
+
+// doRequest sends a request to the admin api, failing over to the next kong
+// node on connection errors and 5xx responses.
+func (kc *kongClient) doRequest(method, path string, body []byte) ([]byte, error) {
+	if kc.nodes == nil {
+		return kc.doRequestTo(kc.kongAdminURL, method, path, body)
+	}
+
+	var respBytes []byte
+	var err error
+
+	for _, node := range kc.nodes.candidates() {
+		respBytes, err = kc.doRequestTo(node.url, method, path, body)
+		if !shouldFailOver(err) {
+			kc.nodes.succeeded(node)
+			log.Printf("%s /%s served by kong node %s", method, path, node.url)
+			return respBytes, err
+		}
+
+		log.Printf("failed to %s /%s on kong node %s: reason: %s", method, path, node.url, err)
+		kc.nodes.failed(node)
 
-	if response.StatusCode >= http.StatusBadRequest {
-		return respBytes, fmt.Errorf("failed with status: %d", response.StatusCode)
+		// a POST might have been applied by the failed node, like a new entry
+		// of the history of a target, the next request goes to the next node
+		if method == http.MethodPost {
+			return respBytes, err
+		}
 	}
 
-	return respBytes, nil
+	return respBytes, err
+}
+
+func shouldFailOver(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	se, ok := err.(statusError)
+	return !ok || se.status >= http.StatusInternalServerError
+}
+
+func (kc *kongClient) doRequestTo(kongAdminURL, method, path string, body []byte) ([]byte, error) {
+	return doJSONRequest(kc.httpClient, method, fmt.Sprintf("%s/%s", kongAdminURL, path), body, kc.auth.applyTo)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// kongAuth is how probed authenticates to the kong admin api, through
+// headers like the key of key-auth, basic credentials or a client certificate
+type kongAuth struct {
+	headers   http.Header
+	username  string
+	password  string
+	tlsConfig *tls.Config
+}
+
+// withHeader sends a header with every request to the admin api, like the
+// apikey header of key-auth or the Kong-Admin-Token of kong enterprise
+func withHeader(name, value string) kongClientOption {
+	return func(kc *kongClient) {
+		if kc.auth.headers == nil {
+			kc.auth.headers = http.Header{}
+		}
+		kc.auth.headers.Set(name, value)
+	}
+}
+
+// withBasicAuth sends basic credentials with every request to the admin api
+func withBasicAuth(username, password string) kongClientOption {
+	return func(kc *kongClient) {
+		kc.auth.username = username
+		kc.auth.password = password
+	}
+}
+
+// withTLSConfig verifies https admin urls and presents client certificates
+// to them as configured
+func withTLSConfig(tlsConfig *tls.Config) kongClientOption {
+	return func(kc *kongClient) {
+		kc.auth.tlsConfig = tlsConfig
+	}
+}
+
+func (ka kongAuth) applyTo(req *http.Request) {
+	for name, values := range ka.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	if ka.username != "" {
+		req.SetBasicAuth(ka.username, ka.password)
+	}
+}
+
+// readSecretFile reads a secret like an api key or a password from a file,
+// so that it does not show up in the args of the process. A trailing newline
+// is dropped.
+func readSecretFile(path string) (string, error) {
+	secretBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %s", err)
+	}
+
+	return strings.TrimRight(string(secretBytes), "\r\n"), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKongClientSendsAuthHeader(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("apikey") != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{ "data" : [ {"id": "1", "name": "upstream1"} ] }`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	_, err := kclient.upstreams()
+	require.Error(t, err, "should have failed without the key")
+
+	withHeader("apikey", "s3cr3t")(kclient)
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed with the key")
+	assert.Equal(t, 1, len(upstreams))
+}
+
+func TestKongClientSendsBasicAuth(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "probed" || password != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{ "data" : [] }`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	withBasicAuth("probed", "s3cr3t")(kclient)
+
+	_, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed with basic credentials")
+}
+
+func TestKongClientPresentsClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir)
+	clientCAs, err := newTLSConfig(tlsOptions{caFile: certFile})
+	require.NoError(t, err)
+
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, 1, len(r.TLS.PeerCertificates))
+		w.Write([]byte(`{ "data" : [ {"id": "1", "name": "upstream1"} ] }`))
+	}))
+	httpServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs.RootCAs}
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	serverCA := filepath.Join(dir, "server.crt")
+	require.NoError(t, ioutil.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.Certificate().Raw}), 0600))
+
+	tlsConfig, err := newTLSConfig(tlsOptions{serverName: "example.com", caFile: serverCA, certFile: certFile, keyFile: keyFile})
+	require.NoError(t, err)
+
+	separator := strings.LastIndex(httpServer.URL, ":")
+	kclient := newKongClient(httpServer.URL[:separator], httpServer.URL[separator+1:], Timeout, withTLSConfig(tlsConfig))
+
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed with a client certificate")
+	assert.Equal(t, 1, len(upstreams))
+
+	tlsConfig, err = newTLSConfig(tlsOptions{serverName: "example.com", caFile: serverCA})
+	require.NoError(t, err)
+
+	kclient = newKongClient(httpServer.URL[:separator], httpServer.URL[separator+1:], Timeout, withTLSConfig(tlsConfig))
+	_, err = kclient.upstreams()
+	assert.Error(t, err, "should have failed without a client certificate")
+}
+
+func TestReadSecretFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "apikey")
+	require.NoError(t, ioutil.WriteFile(secretFile, []byte("s3cr3t\n"), 0600))
+
+	secret, err := readSecretFile(secretFile)
+	require.NoError(t, err, "should not have failed to read secret")
+	assert.Equal(t, "s3cr3t", secret)
+
+	_, err = readSecretFile(filepath.Join(dir, "missing"))
+	assert.Error(t, err, "should have failed to read a missing secret")
+}
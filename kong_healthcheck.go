@@ -10,12 +10,24 @@ import (
 type kongHealthCheckConfig struct {
 	healthCheckPath     string
 	healthCheckInterval string
+	ejections           *ejectionGuard
+	policies            *checkPolicies
+	weights             *weightStore
+	filter              *upstreamFilter
+	checkZeroWeight     bool
 }
 
 type kongHealthCheck struct {
-	ticker     *time.Ticker
-	targetChan chan target
-	client     Client
+	ticker          *time.Ticker
+	tickInterval    time.Duration
+	targetChan      chan target
+	client          Client
+	ejections       *ejectionGuard
+	policies        *checkPolicies
+	weights         *weightStore
+	filter          *upstreamFilter
+	checkZeroWeight bool
+	lastChecked     map[string]time.Time
 
 	wg sync.WaitGroup
 }
@@ -26,10 +38,22 @@ func newKongHealthCheck(targetChan chan target, client Client, hcConfig *kongHea
 		return nil, err
 	}
 
+	tickInterval := time.Millisecond * time.Duration(hcInterval)
+	if hcConfig.policies != nil && hcConfig.policies.minInterval() < tickInterval {
+		tickInterval = hcConfig.policies.minInterval()
+	}
+
 	return &kongHealthCheck{
-		ticker:     time.NewTicker(time.Millisecond * time.Duration(hcInterval)),
-		client:     client,
-		targetChan: targetChan,
+		ticker:          time.NewTicker(tickInterval),
+		tickInterval:    tickInterval,
+		client:          client,
+		targetChan:      targetChan,
+		ejections:       hcConfig.ejections,
+		policies:        hcConfig.policies,
+		weights:         hcConfig.weights,
+		filter:          hcConfig.filter,
+		checkZeroWeight: hcConfig.checkZeroWeight,
+		lastChecked:     make(map[string]time.Time),
 	}, nil
 }
 
@@ -56,25 +80,118 @@ func (khc *kongHealthCheck) monitorHealthOfTargets(targetChan chan target) {
 		return
 	}
 
+	now := time.Now()
 	for _, u := range upstreams {
+		if !khc.filter.allows(u) {
+			continue
+		}
+
+		policy := khc.policyFor(u)
+		if !khc.isDue(u, policy, now) {
+			continue
+		}
+
 		khc.wg.Add(1)
 		go func(u upstream) {
 			defer khc.wg.Done()
-			khc.fetchAndQueueTargetsFor(u.ID, targetChan)
+			khc.fetchAndQueueTargetsFor(u.ID, policy, targetChan)
 		}(u)
 	}
 
 	return
 }
 
-func (khc *kongHealthCheck) fetchAndQueueTargetsFor(upstreamID string, targetChan chan target) {
+func (khc *kongHealthCheck) policyFor(u upstream) *checkPolicy {
+	if khc.policies == nil {
+		return nil
+	}
+
+	return khc.policies.forUpstream(u)
+}
+
+// isDue reports whether the interval of the upstream has elapsed since it
+// was last checked, allowing half a tick of jitter.
+func (khc *kongHealthCheck) isDue(u upstream, policy *checkPolicy, now time.Time) bool {
+	if policy == nil {
+		return true
+	}
+
+	lastChecked, ok := khc.lastChecked[u.ID]
+	if ok && now.Sub(lastChecked) < policy.interval-khc.tickInterval/2 {
+		return false
+	}
+
+	khc.lastChecked[u.ID] = now
+	return true
+}
+
+// currentTargets collapses the history kong keeps for a target address to its
+// newest entry. A newest entry with a weight of 0 is only checked when it is
+// the entry probed wrote to mark the target unhealthy, any other one means the
+// target was removed from kong. Targets probed marked unhealthy which were
+// removed afterwards are forgotten.
+func (khc *kongHealthCheck) currentTargets(targets []target) []target {
+	current := newestTargets(targets)
+
+	checked := current[:0]
+	for _, t := range current {
+		if t.Weight > unhealthyNodeWeight || t.Health != "" || t.CreatedAt == 0 {
+			checked = append(checked, t)
+			continue
+		}
+
+		entryID, ok := khc.weights.entryFor(t)
+		if ok && entryID != t.ID {
+			log.Printf("target %s was removed after it was marked unhealthy, forgetting its weight", t.URL)
+			err := khc.weights.forget(t)
+			if err != nil {
+				log.Printf("failed to forget weight of target %s: reason: %s", t.URL, err)
+			}
+
+			continue
+		}
+
+		if ok || khc.weights.remembers(t) || khc.checkZeroWeight {
+			checked = append(checked, t)
+		}
+	}
+
+	return checked
+}
+
+// newestTargets keeps the newest entry by created_at of every target address
+func newestTargets(targets []target) []target {
+	newest := make(map[string]int, len(targets))
+	current := []target{}
+
+	for _, t := range targets {
+		i, ok := newest[t.URL]
+		if !ok {
+			newest[t.URL] = len(current)
+			current = append(current, t)
+			continue
+		}
+
+		if t.CreatedAt > current[i].CreatedAt {
+			current[i] = t
+		}
+	}
+
+	return current
+}
+
+func (khc *kongHealthCheck) fetchAndQueueTargetsFor(upstreamID string, policy *checkPolicy, targetChan chan target) {
 	targets, err := khc.client.targetsFor(upstreamID)
 	if err != nil {
 		log.Printf("failed to fetch targets for upstream %s: %s", upstreamID, err)
 		return
 	}
 
+	targets = khc.currentTargets(targets)
+	khc.ejections.observe(upstreamID, targets)
+
 	for _, target := range targets {
+		target.policy = policy
 		targetChan <- target
 	}
 }
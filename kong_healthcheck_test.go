@@ -183,3 +183,218 @@ func TestKongHealthCheckStartWhenFetchTargetFailsForAUpstream(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestKongHealthCheckObservesTargetsForEjectionLimit(t *testing.T) {
+	targetChan := make(chan target, 100)
+	mockClient := new(mockClient)
+
+	upstreamTargets := []target{
+		{ID: "1.1", URL: "1.2.3.4:80", Weight: 0, UpstreamID: "1"},
+		{ID: "1.2", URL: "1.2.3.5:80", Weight: 100, UpstreamID: "1"},
+	}
+
+	mockClient.On("upstreams").Return([]upstream{{ID: "1", Name: "upstream1"}}, nil)
+	mockClient.On("targetsFor", "1").Return(upstreamTargets, nil)
+
+	ejections := newEjectionGuard(ejectionLimit{value: 1})
+	kongHealthCheckConfig := &kongHealthCheckConfig{
+		healthCheckPath:     "/ping",
+		healthCheckInterval: "10",
+		ejections:           ejections,
+	}
+
+	kongHealthCheck, err := newKongHealthCheck(targetChan, mockClient, kongHealthCheckConfig)
+	require.NoError(t, err, "should not have failed to intialize kong health check")
+
+	go kongHealthCheck.start()
+	defer kongHealthCheck.stop()
+
+	predicate := func() bool {
+		return len(targetChan) >= 2
+	}
+
+	successful := asyncwait.NewAsyncWait(100, 5).Check(predicate)
+	require.True(t, successful)
+
+	assert.Error(t, ejections.reserve(upstreamTargets[1]), "should have observed the unhealthy target of the upstream")
+}
+
+func TestKongHealthCheckQueuesTargetsWithPolicyOfUpstream(t *testing.T) {
+	targetChan := make(chan target, 100)
+	mockClient := new(mockClient)
+
+	cfg := &probedConfig{Upstreams: []upstreamCheckConfig{
+		{Name: "redis", Interval: duration(time.Hour)},
+	}}
+	defaults := checkDefaults{checkType: "tcp", interval: 10 * time.Millisecond, rise: 1, fall: 1}
+
+	policies, err := newCheckPolicies(cfg, defaults, false)
+	require.NoError(t, err)
+
+	mockClient.On("upstreams").Return([]upstream{{ID: "1", Name: "redis"}, {ID: "2", Name: "orders"}}, nil)
+	mockClient.On("targetsFor", "1").Return([]target{{ID: "1.1", URL: "1.2.3.4:6379", Weight: 100}}, nil).Once()
+	mockClient.On("targetsFor", "2").Return([]target{{ID: "2.1", URL: "1.2.3.5:80", Weight: 100}}, nil)
+
+	kongHealthCheckConfig := &kongHealthCheckConfig{
+		healthCheckPath:     "/ping",
+		healthCheckInterval: "10",
+		policies:            policies,
+	}
+
+	kongHealthCheck, err := newKongHealthCheck(targetChan, mockClient, kongHealthCheckConfig)
+	require.NoError(t, err, "should not have failed to intialize kong health check")
+
+	go kongHealthCheck.start()
+	defer kongHealthCheck.stop()
+
+	predicate := func() bool {
+		return len(targetChan) >= 4
+	}
+
+	successful := asyncwait.NewAsyncWait(200, 5).Check(predicate)
+	require.True(t, successful)
+
+	queued := map[string]int{}
+	for i := 0; i < 4; i++ {
+		target := <-targetChan
+		queued[target.ID]++
+
+		if target.ID == "1.1" {
+			assert.Equal(t, policies.forUpstream(upstream{Name: "redis"}), target.policy)
+		} else {
+			assert.Equal(t, policies.defaults, target.policy)
+		}
+	}
+
+	assert.Equal(t, 1, queued["1.1"], "should have checked redis only once within its interval")
+	assert.Equal(t, 3, queued["2.1"])
+}
+
+func TestKongHealthCheckQueuesNewestEntryOfTargetHistory(t *testing.T) {
+	targetChan := make(chan target, 100)
+	mockClient := new(mockClient)
+
+	weights, err := newWeightStore("")
+	require.NoError(t, err)
+	require.NoError(t, weights.remember(target{URL: "1.2.3.6:80", Weight: 50, UpstreamID: "1"}))
+
+	mockClient.On("targetsFor", "1").Return([]target{
+		{ID: "1.1", URL: "1.2.3.4:80", Weight: 100, UpstreamID: "1", CreatedAt: 1520000000000},
+		{ID: "1.2", URL: "1.2.3.4:80", Weight: 50, UpstreamID: "1", CreatedAt: 1520000005000},
+		{ID: "1.3", URL: "1.2.3.5:80", Weight: 100, UpstreamID: "1", CreatedAt: 1520000001000},
+		{ID: "1.4", URL: "1.2.3.5:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000002000},
+		{ID: "1.5", URL: "1.2.3.6:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000003000},
+		{ID: "1.6", URL: "1.2.3.6:80", Weight: 50, UpstreamID: "1", CreatedAt: 1520000001000},
+		{ID: "1.7", URL: "1.2.3.7:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000004000},
+		{ID: "1.8", URL: "1.2.3.7:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000002000},
+		{ID: "1.9", URL: "1.2.3.7:80", Weight: 100, UpstreamID: "1", CreatedAt: 1520000001000},
+	}, nil)
+
+	kongHealthCheck, err := newKongHealthCheck(targetChan, mockClient, &kongHealthCheckConfig{
+		healthCheckPath:     "/ping",
+		healthCheckInterval: "10",
+		weights:             weights,
+	})
+	require.NoError(t, err, "should not have failed to intialize kong health check")
+
+	kongHealthCheck.fetchAndQueueTargetsFor("1", nil, targetChan)
+	close(targetChan)
+
+	queued := []string{}
+	for target := range targetChan {
+		queued = append(queued, target.ID)
+	}
+
+	assert.Equal(t, []string{"1.2", "1.5"}, queued, "should skip superseded entries and targets removed from kong")
+}
+
+func TestKongHealthCheckSkipsTargetsWithWeightZeroAfterRestart(t *testing.T) {
+	targetChan := make(chan target, 100)
+	mockClient := new(mockClient)
+
+	mockClient.On("targetsFor", "1").Return([]target{
+		{ID: "1.1", URL: "1.2.3.4:80", Weight: 100, UpstreamID: "1", CreatedAt: 1520000000000},
+		{ID: "1.2", URL: "1.2.3.4:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000001000},
+		{ID: "1.3", URL: "1.2.3.5:80", Weight: 0, UpstreamID: "1"},
+	}, nil)
+
+	// an empty weight store, like after a restart without -state-file
+	weights, err := newWeightStore("")
+	require.NoError(t, err)
+
+	kongHealthCheck, err := newKongHealthCheck(targetChan, mockClient, &kongHealthCheckConfig{
+		healthCheckPath:     "/ping",
+		healthCheckInterval: "10",
+		weights:             weights,
+	})
+	require.NoError(t, err, "should not have failed to intialize kong health check")
+
+	kongHealthCheck.fetchAndQueueTargetsFor("1", nil, targetChan)
+	require.Equal(t, 1, len(targetChan), "should skip entries with a weight of 0 probed did not add")
+	assert.Equal(t, "1.3", (<-targetChan).ID, "should check targets without a history")
+
+	kongHealthCheck.checkZeroWeight = true
+	kongHealthCheck.fetchAndQueueTargetsFor("1", nil, targetChan)
+	require.Equal(t, 2, len(targetChan), "should check targets with a weight of 0 when asked to")
+	assert.Equal(t, "1.2", (<-targetChan).ID)
+	assert.Equal(t, "1.3", (<-targetChan).ID)
+}
+
+func TestKongHealthCheckQueuesOnlyEntriesProbedMarkedUnhealthy(t *testing.T) {
+	targetChan := make(chan target, 100)
+	mockClient := new(mockClient)
+
+	weights, err := newWeightStore("")
+	require.NoError(t, err)
+	require.NoError(t, weights.remember(target{URL: "1.2.3.4:80", Weight: 50, UpstreamID: "1"}))
+	require.NoError(t, weights.rememberEntry(target{URL: "1.2.3.4:80", UpstreamID: "1"}, "1.2"))
+	require.NoError(t, weights.remember(target{URL: "1.2.3.5:80", Weight: 100, UpstreamID: "1"}))
+	require.NoError(t, weights.rememberEntry(target{URL: "1.2.3.5:80", UpstreamID: "1"}, "1.4"))
+
+	mockClient.On("targetsFor", "1").Return([]target{
+		{ID: "1.1", URL: "1.2.3.4:80", Weight: 50, UpstreamID: "1", CreatedAt: 1520000000000},
+		{ID: "1.2", URL: "1.2.3.4:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000001000},
+		{ID: "1.3", URL: "1.2.3.5:80", Weight: 100, UpstreamID: "1", CreatedAt: 1520000000000},
+		{ID: "1.4", URL: "1.2.3.5:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000001000},
+		{ID: "1.5", URL: "1.2.3.5:80", Weight: 0, UpstreamID: "1", CreatedAt: 1520000002000},
+	}, nil)
+
+	kongHealthCheck, err := newKongHealthCheck(targetChan, mockClient, &kongHealthCheckConfig{
+		healthCheckPath:     "/ping",
+		healthCheckInterval: "10",
+		weights:             weights,
+	})
+	require.NoError(t, err, "should not have failed to intialize kong health check")
+
+	kongHealthCheck.fetchAndQueueTargetsFor("1", nil, targetChan)
+	require.Equal(t, 1, len(targetChan), "should skip targets removed after probed marked them unhealthy")
+	assert.Equal(t, "1.2", (<-targetChan).ID)
+
+	assert.True(t, weights.remembers(target{URL: "1.2.3.4:80", UpstreamID: "1"}))
+	assert.False(t, weights.remembers(target{URL: "1.2.3.5:80", UpstreamID: "1"}), "should forget the weight of a removed target")
+}
+
+func TestKongHealthCheckSkipsFilteredUpstreams(t *testing.T) {
+	targetChan := make(chan target, 100)
+	mockClient := new(mockClient)
+
+	filter, err := newUpstreamFilter("orders*", "")
+	require.NoError(t, err)
+
+	mockClient.On("upstreams").Return([]upstream{{ID: "1", Name: "orders"}, {ID: "2", Name: "search"}}, nil)
+	mockClient.On("targetsFor", "1").Return([]target{{ID: "1.1", URL: "1.2.3.4:80", Weight: 100}}, nil)
+
+	kongHealthCheck, err := newKongHealthCheck(targetChan, mockClient, &kongHealthCheckConfig{
+		healthCheckPath:     "/ping",
+		healthCheckInterval: "10",
+		filter:              filter,
+	})
+	require.NoError(t, err, "should not have failed to intialize kong health check")
+
+	kongHealthCheck.monitorHealthOfTargets(targetChan)
+	kongHealthCheck.wg.Wait()
+
+	require.Equal(t, 1, len(targetChan))
+	assert.Equal(t, "1.1", (<-targetChan).ID)
+	mockClient.AssertNotCalled(t, "targetsFor", "2")
+}
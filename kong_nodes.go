@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const kongNodeBackoff = 1 * time.Second
+const kongNodeMaxBackoff = 30 * time.Second
+
+// kongNode is the admin api of a kong node, which is skipped for a backoff
+// after failing
+type kongNode struct {
+	url      string
+	failures int
+	retryAt  time.Time
+}
+
+// kongNodes fails over between the admin apis of the nodes of a kong cluster,
+// which share a database, preferring the node which served the last request.
+type kongNodes struct {
+	mu      sync.Mutex
+	nodes   []*kongNode
+	current int
+}
+
+func newKongNodes(urls []string) *kongNodes {
+	nodes := make([]*kongNode, 0, len(urls))
+	for _, url := range urls {
+		nodes = append(nodes, &kongNode{url: url})
+	}
+
+	return &kongNodes{nodes: nodes}
+}
+
+// candidates lists the nodes in the order they are tried, starting at the
+// current node. Nodes backing off are tried last, so that requests still go
+// out when every node has failed.
+func (kn *kongNodes) candidates() []*kongNode {
+	kn.mu.Lock()
+	defer kn.mu.Unlock()
+
+	now := time.Now()
+	available := []*kongNode{}
+	backingOff := []*kongNode{}
+
+	for i := range kn.nodes {
+		node := kn.nodes[(kn.current+i)%len(kn.nodes)]
+		if now.Before(node.retryAt) {
+			backingOff = append(backingOff, node)
+			continue
+		}
+
+		available = append(available, node)
+	}
+
+	return append(available, backingOff...)
+}
+
+func (kn *kongNodes) succeeded(node *kongNode) {
+	kn.mu.Lock()
+	defer kn.mu.Unlock()
+
+	node.failures = 0
+	node.retryAt = time.Time{}
+
+	for i, n := range kn.nodes {
+		if n == node {
+			kn.current = i
+		}
+	}
+}
+
+func (kn *kongNodes) failed(node *kongNode) {
+	kn.mu.Lock()
+	defer kn.mu.Unlock()
+
+	node.failures++
+	node.retryAt = time.Now().Add(backoffFor(node.failures))
+}
+
+// backoffFor doubles the backoff of a node with every failure in a row, up to
+// kongNodeMaxBackoff
+func backoffFor(failures int) time.Duration {
+	backoff := kongNodeBackoff
+	for i := 1; i < failures && backoff < kongNodeMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > kongNodeMaxBackoff {
+		return kongNodeMaxBackoff
+	}
+
+	return backoff
+}
+
+// kongAdminURLs splits a comma separated list of kong hosts into admin urls,
+// the admin port is added to hosts which do not have a port.
+func kongAdminURLs(kongHosts, kongAdminPort string) []string {
+	urls := []string{}
+	for _, host := range strings.Split(kongHosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+
+		hostPort := host
+		if i := strings.Index(host, "://"); i >= 0 {
+			hostPort = host[i+len("://"):]
+		}
+
+		if strings.Contains(hostPort, ":") {
+			urls = append(urls, host)
+			continue
+		}
+
+		urls = append(urls, host+":"+kongAdminPort)
+	}
+
+	return urls
+}
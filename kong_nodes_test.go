@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCountingAdminServer(status int, requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(status)
+		w.Write([]byte(`{ "data" : [ {"id": "1", "name": "upstream1"} ] }`))
+	}))
+}
+
+func TestKongClientFailsOverOnServerErrors(t *testing.T) {
+	var failingRequests, healthyRequests atomic.Int32
+
+	failingServer := newCountingAdminServer(http.StatusServiceUnavailable, &failingRequests)
+	defer failingServer.Close()
+	healthyServer := newCountingAdminServer(http.StatusOK, &healthyRequests)
+	defer healthyServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: failingServer.URL, nodes: newKongNodes([]string{failingServer.URL, healthyServer.URL})}
+
+	for i := 0; i < 2; i++ {
+		upstreams, err := kclient.upstreams()
+		require.NoError(t, err, "should not have failed to get upstreams from the healthy node")
+		assert.Equal(t, 1, len(upstreams))
+	}
+
+	assert.Equal(t, int32(1), failingRequests.Load(), "should have backed off from the failing node")
+	assert.Equal(t, int32(2), healthyRequests.Load())
+}
+
+func TestKongClientFailsOverOnConnectionErrors(t *testing.T) {
+	var requests atomic.Int32
+
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+	healthyServer := newCountingAdminServer(http.StatusOK, &requests)
+	defer healthyServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: closedServer.URL, nodes: newKongNodes([]string{closedServer.URL, healthyServer.URL})}
+
+	_, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams from the healthy node")
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestKongClientDoesNotFailOverOnClientErrors(t *testing.T) {
+	var notFoundRequests, healthyRequests atomic.Int32
+
+	notFoundServer := newCountingAdminServer(http.StatusNotFound, &notFoundRequests)
+	defer notFoundServer.Close()
+	healthyServer := newCountingAdminServer(http.StatusOK, &healthyRequests)
+	defer healthyServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: notFoundServer.URL, nodes: newKongNodes([]string{notFoundServer.URL, healthyServer.URL})}
+
+	_, err := kclient.upstreams()
+	require.Error(t, err, "should have failed with the response of the first node")
+	assert.Equal(t, int32(0), healthyRequests.Load())
+}
+
+func TestKongClientFailsWhenEveryNodeFails(t *testing.T) {
+	var requests atomic.Int32
+
+	failingServer := newCountingAdminServer(http.StatusInternalServerError, &requests)
+	defer failingServer.Close()
+	otherFailingServer := newCountingAdminServer(http.StatusBadGateway, &requests)
+	defer otherFailingServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: failingServer.URL, nodes: newKongNodes([]string{failingServer.URL, otherFailingServer.URL})}
+
+	for i := 0; i < 2; i++ {
+		_, err := kclient.upstreams()
+		require.Error(t, err, "should have failed when every node fails")
+	}
+
+	assert.Equal(t, int32(4), requests.Load(), "should still try nodes backing off when every node fails")
+}
+
+func TestKongNodesPreferTheNodeServingTheLastRequest(t *testing.T) {
+	nodes := newKongNodes([]string{"http://kong1:8001", "http://kong2:8001", "http://kong3:8001"})
+
+	candidates := nodes.candidates()
+	nodes.failed(candidates[0])
+	nodes.succeeded(candidates[1])
+
+	urls := []string{}
+	for _, node := range nodes.candidates() {
+		urls = append(urls, node.url)
+	}
+
+	assert.Equal(t, []string{"http://kong2:8001", "http://kong3:8001", "http://kong1:8001"}, urls)
+}
+
+func TestBackoffFor(t *testing.T) {
+	assert.Equal(t, time.Second, backoffFor(1))
+	assert.Equal(t, 2*time.Second, backoffFor(2))
+	assert.Equal(t, 16*time.Second, backoffFor(5))
+	assert.Equal(t, kongNodeMaxBackoff, backoffFor(6))
+	assert.Equal(t, kongNodeMaxBackoff, backoffFor(100))
+}
+
+func TestKongAdminURLs(t *testing.T) {
+	urls := kongAdminURLs("http://kong1, http://kong2:8444,https://kong3,", "8001")
+
+	assert.Equal(t, []string{"http://kong1:8001", "http://kong2:8444", "https://kong3:8001"}, urls)
+}
+
+func TestNewKongClientWithSeveralNodes(t *testing.T) {
+	kclient := newKongClient("http://kong1,http://kong2", "8001", Timeout)
+
+	assert.Equal(t, "http://kong1:8001", kclient.kongAdminURL)
+	require.NotNil(t, kclient.nodes)
+	assert.Equal(t, 2, len(kclient.nodes.candidates()))
+	assert.True(t, strings.HasPrefix(kclient.nodes.candidates()[1].url, "http://kong2"))
+
+	assert.Nil(t, newKongClient("http://kong1", "8001", Timeout).nodes)
+}
+
+func TestKongClientDoesNotRetryPostsOnTheNextNode(t *testing.T) {
+	var failingRequests, healthyRequests atomic.Int32
+
+	failingServer := newCountingAdminServer(http.StatusBadGateway, &failingRequests)
+	defer failingServer.Close()
+	healthyServer := newCountingAdminServer(http.StatusOK, &healthyRequests)
+	defer healthyServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: failingServer.URL, nodes: newKongNodes([]string{failingServer.URL, healthyServer.URL})}
+
+	_, err := kclient.doRequest(http.MethodPost, "upstreams/1/targets", []byte(`{"target": "1.2.3.4:80", "weight": 0}`))
+	require.Error(t, err, "should not have sent the post again to the next node")
+	assert.Equal(t, int32(0), healthyRequests.Load())
+
+	_, err = kclient.doRequest(http.MethodPost, "upstreams/1/targets", []byte(`{"target": "1.2.3.4:80", "weight": 0}`))
+	require.NoError(t, err, "should have sent the next post to the next node")
+	assert.Equal(t, int32(1), failingRequests.Load())
+	assert.Equal(t, int32(1), healthyRequests.Load())
+}
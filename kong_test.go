@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
@@ -128,3 +129,176 @@ func TestSetTargetWeightForFailure(t *testing.T) {
 	err := kclient.setTargetWeightFor("upstream1", "target1", 100)
 	require.Error(t, err, "should have failed to set target weight")
 }
+
+func TestUpstreamsDecodesKongHealthchecks(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{ "data" : [ {"id": "123-123", "name": "upstream1", "healthchecks": {"active": {"timeout": 1, "http_path": "/status", "healthy": {"interval": 5, "successes": 2}, "unhealthy": {"http_failures": 3}}}}, {"id": "123-124", "name": "upstream2" }] }`))
+	}))
+
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	require.Equal(t, 2, len(upstreams))
+
+	require.NotNil(t, upstreams[0].Healthchecks)
+	active := upstreams[0].Healthchecks.Active
+	require.NotNil(t, active)
+	assert.Equal(t, float64(1), active.Timeout)
+	assert.Equal(t, "/status", active.HTTPPath)
+	assert.Equal(t, float64(5), active.Healthy.Interval)
+	assert.Equal(t, 2, active.Healthy.Successes)
+	assert.Equal(t, 3, active.Unhealthy.HTTPFailures)
+
+	assert.Nil(t, upstreams[1].Healthchecks)
+}
+
+func newPaginatedAdminServer(t *testing.T, path string, pages []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, path, r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("size"))
+
+		page := 0
+		if offset := r.URL.Query().Get("offset"); offset != "" {
+			var err error
+			page, err = strconv.Atoi(offset)
+			require.NoError(t, err)
+		}
+
+		w.Write([]byte(pages[page]))
+	}))
+}
+
+func TestUpstreamsFollowsPagination(t *testing.T) {
+	httpServer := newPaginatedAdminServer(t, "/upstreams", []string{
+		`{ "data" : [ {"id": "1", "name": "upstream1"}, {"id": "2", "name": "upstream2"} ], "offset": "1", "next": "/upstreams?offset=1" }`,
+		`{ "data" : [ {"id": "3", "name": "upstream3"}, {"id": "4", "name": "upstream4"} ], "offset": "2", "next": "/upstreams?offset=2" }`,
+		`{ "data" : [ {"id": "5", "name": "upstream5"} ], "next": null }`,
+	})
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, pageSize: 2}
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	require.Equal(t, 5, len(upstreams))
+	for i, u := range upstreams {
+		assert.Equal(t, strconv.Itoa(i+1), u.ID)
+	}
+}
+
+func TestTargetsForFollowsPagination(t *testing.T) {
+	httpServer := newPaginatedAdminServer(t, "/upstreams/upstream1/targets", []string{
+		`{ "data" : [ {"id": "1", "target": "1.2.3.4:80", "weight": 100}, {"id": "2", "target": "1.2.3.5:80", "weight": 100} ], "offset": "1" }`,
+		`{ "data" : [ {"id": "3", "target": "1.2.3.6:80", "weight": 0} ] }`,
+	})
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, pageSize: 2}
+	targets, err := kclient.targetsFor("upstream1")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 3, len(targets))
+	assert.Equal(t, "1.2.3.6:80", targets[2].URL)
+}
+
+func TestPaginationFailsOnAFailedPage(t *testing.T) {
+	httpServer := newPaginatedAdminServer(t, "/upstreams", []string{
+		`{ "data" : [ {"id": "1", "name": "upstream1"}, {"id": "2", "name": "upstream2"} ], "offset": "1" }`,
+		`{ "data" : [ {"id": "3", "name": "upstream3"} `,
+	})
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, pageSize: 2}
+	upstreams, err := kclient.upstreams()
+	require.Error(t, err, "should have failed to get upstreams")
+	assert.Equal(t, 0, len(upstreams))
+}
+
+func TestPaginationFailsOnRepeatedOffset(t *testing.T) {
+	httpServer := newPaginatedAdminServer(t, "/upstreams", []string{
+		`{ "data" : [ {"id": "1", "name": "upstream1"} ], "offset": "1" }`,
+		`{ "data" : [ {"id": "2", "name": "upstream2"} ], "offset": "1" }`,
+	})
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, pageSize: 2}
+	_, err := kclient.upstreams()
+	require.Error(t, err, "should have failed on a repeated offset")
+}
+
+func TestNewKongClientWithPageSize(t *testing.T) {
+	kclient := newKongClient("127.0.0.1", "9000", Timeout, withPageSize(500))
+
+	assert.Equal(t, 500, kclient.pageSize)
+}
+
+func TestTargetsForDecodesNestedUpstream(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{ "data" : [ {"id": "123-123", "target": "1.2.3.4:8080", "weight": 100, "upstream": {"id": "123-122"}} ], "next": null }`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	targets, err := kclient.targetsFor("123-122")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 1, len(targets))
+	assert.Equal(t, "123-122", targets[0].UpstreamID)
+	assert.Equal(t, 100, targets[0].Weight)
+}
+
+func TestTargetsForDecodesCreatedAt(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{ "data" : [ {"target": "1.2.3.4:8080", "weight": 100, "created_at": 1520000005000}, {"target": "1.2.3.5:8080", "weight": 100, "created_at": 1520000005.123} ] }`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	targets, err := kclient.targetsFor("upstream1")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 2, len(targets))
+	assert.Equal(t, float64(1520000005000), targets[0].CreatedAt)
+	assert.Equal(t, 1520000005.123, targets[1].CreatedAt)
+}
+
+func TestUpstreamsFilteredByTags(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"version": "1.1.0"}`))
+			return
+		}
+
+		assert.Equal(t, "probed,team-a", r.URL.Query().Get("tags"))
+		w.Write([]byte(`{ "data" : [ {"id": "1", "name": "upstream1"} ], "offset": null }`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	withTags("probed,team-a")(kclient)
+
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+	assert.Equal(t, 1, len(upstreams))
+}
+
+func TestUpstreamsFilteredByTagsFailsBeforeKong11(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"version": "1.0.3"}`))
+			return
+		}
+
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	withTags("probed")(kclient)
+
+	_, err := kclient.upstreams()
+	require.Error(t, err, "should have failed to filter upstreams by tags")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var kongVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// kongVersion is the major and minor version of kong, which decide how
+// targets are written through its admin api
+type kongVersion struct {
+	major int
+	minor int
+}
+
+// legacyKongVersion is assumed when kong does not report a version, kong
+// before 1.0 only supports appending to the history of a target.
+var legacyKongVersion = kongVersion{}
+
+func parseKongVersion(version string) (kongVersion, error) {
+	matches := kongVersionPattern.FindStringSubmatch(version)
+	if matches == nil {
+		return kongVersion{}, fmt.Errorf("invalid kong version %q", version)
+	}
+
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+
+	return kongVersion{major: major, minor: minor}, nil
+}
+
+func (kv kongVersion) atLeast(major, minor int) bool {
+	return kv.major > major || (kv.major == major && kv.minor >= minor)
+}
+
+// patchesTargets reports whether existing targets are updated in place,
+// kong 2.2 added PATCH on targets and 3.0 rejects duplicate targets.
+func (kv kongVersion) patchesTargets() bool {
+	return kv.atLeast(2, 2)
+}
+
+// hasHealthEndpoints reports whether targets can be marked healthy and
+// unhealthy, which kong added in 0.12.
+func (kv kongVersion) hasHealthEndpoints() bool {
+	return kv.atLeast(0, 12)
+}
+
+func (kv kongVersion) String() string {
+	return fmt.Sprintf("%d.%d", kv.major, kv.minor)
+}
+
+type kongRootResponse struct {
+	Version string `json:"version"`
+}
+
+// detectVersion reads the version of kong from the root of the admin api once,
+// failed requests are retried on the next call.
+func (kc *kongClient) detectVersion() (kongVersion, error) {
+	kc.versionMu.Lock()
+	defer kc.versionMu.Unlock()
+
+	if kc.version != nil {
+		return *kc.version, nil
+	}
+
+	respBytes, err := kc.doRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return kongVersion{}, fmt.Errorf("failed to detect kong version: %s", err)
+	}
+
+	version := legacyKongVersion
+
+	root := &kongRootResponse{}
+	err = json.Unmarshal(respBytes, root)
+	if err == nil {
+		version, err = parseKongVersion(root.Version)
+	}
+	if err != nil {
+		log.Printf("failed to detect kong version, assuming kong %s: reason: %s", legacyKongVersion, err)
+		version = legacyKongVersion
+	}
+
+	kc.version = &version
+	return version, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type targetWrite struct {
+	method string
+	path   string
+	target target
+}
+
+// newVersionedAdminServer fakes the admin api of a kong version, writes to
+// targets are sent on the returned channel and POSTs answer with the entry
+// "entry1"
+func newVersionedAdminServer(t *testing.T, version string) (*httptest.Server, chan targetWrite) {
+	writes := make(chan targetWrite, 1)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"hostname": "kong", "version": "` + version + `"}`))
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		write := targetWrite{method: r.Method, path: r.URL.EscapedPath()}
+		if len(body) > 0 {
+			require.NoError(t, json.Unmarshal(body, &write.target))
+		}
+		writes <- write
+
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"id": "entry1"}`))
+		}
+	}))
+
+	return httpServer, writes
+}
+
+func TestParseKongVersion(t *testing.T) {
+	versions := map[string]kongVersion{
+		"0.14.1":                     {major: 0, minor: 14},
+		"1.5.0":                      {major: 1, minor: 5},
+		"2.8.1":                      {major: 2, minor: 8},
+		"2.8.1.0-enterprise-edition": {major: 2, minor: 8},
+		"3.4.0.0":                    {major: 3, minor: 4},
+	}
+
+	for raw, expected := range versions {
+		version, err := parseKongVersion(raw)
+		require.NoError(t, err, "should not have failed to parse %s", raw)
+		assert.Equal(t, expected, version)
+	}
+
+	_, err := parseKongVersion("next")
+	assert.Error(t, err, "should have failed to parse an invalid version")
+}
+
+func TestSetTargetWeightForByKongVersion(t *testing.T) {
+	strategies := map[string]targetWrite{
+		"0.14.1":  {method: http.MethodPost, path: "/upstreams/upstream1/targets"},
+		"1.5.0":   {method: http.MethodPost, path: "/upstreams/upstream1/targets"},
+		"2.1.4":   {method: http.MethodPost, path: "/upstreams/upstream1/targets"},
+		"2.2.0":   {method: http.MethodPatch, path: "/upstreams/upstream1/targets/1.2.3.4:8080"},
+		"3.4.0.0": {method: http.MethodPatch, path: "/upstreams/upstream1/targets/1.2.3.4:8080"},
+	}
+
+	for version, expected := range strategies {
+		httpServer, writes := newVersionedAdminServer(t, version)
+
+		kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+		err := kclient.setTargetWeightFor("upstream1", "1.2.3.4:8080", 0)
+		require.NoError(t, err, "should not have failed to set target weight on kong %s", version)
+
+		write := <-writes
+		assert.Equal(t, expected.method, write.method, "kong %s", version)
+		assert.Equal(t, expected.path, write.path, "kong %s", version)
+		assert.Equal(t, "1.2.3.4:8080", write.target.URL)
+		assert.Equal(t, 0, write.target.Weight)
+
+		httpServer.Close()
+	}
+}
+
+func TestMarkUnhealthyEntryByKongVersion(t *testing.T) {
+	entries := map[string]string{
+		"1.5.0": "entry1",
+		"2.1.4": "entry1",
+		"2.2.0": "",
+	}
+
+	for version, expected := range entries {
+		httpServer, writes := newVersionedAdminServer(t, version)
+
+		kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+		entryID, err := kclient.markUnhealthyEntry("upstream1", "1.2.3.4:8080")
+		require.NoError(t, err, "should not have failed to mark target unhealthy on kong %s", version)
+		assert.Equal(t, expected, entryID, "kong %s", version)
+
+		write := <-writes
+		assert.Equal(t, 0, write.target.Weight)
+
+		httpServer.Close()
+	}
+}
+
+func TestDetectVersionOnce(t *testing.T) {
+	var rootRequests atomic.Int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rootRequests.Add(1)
+		w.Write([]byte(`{"version": "3.0.0"}`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	for i := 0; i < 2; i++ {
+		version, err := kclient.detectVersion()
+		require.NoError(t, err, "should not have failed to detect version")
+		assert.Equal(t, kongVersion{major: 3, minor: 0}, version)
+	}
+
+	assert.Equal(t, int32(1), rootRequests.Load())
+}
+
+func TestDetectVersionAssumesLegacyKongWithoutVersion(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tagline": "Welcome to kong"}`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	version, err := kclient.detectVersion()
+	require.NoError(t, err, "should not have failed to detect version")
+	assert.Equal(t, legacyKongVersion, version)
+}
+
+func TestDetectVersionRetriesFailures(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version": "2.8.1"}`))
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	_, err := kclient.detectVersion()
+	require.Error(t, err, "should have failed to detect version")
+
+	failing.Store(false)
+	version, err := kclient.detectVersion()
+	require.NoError(t, err, "should not have failed to detect version")
+	assert.Equal(t, kongVersion{major: 2, minor: 8}, version)
+}
+
+func TestSetTargetWeightForThroughHealthEndpoints(t *testing.T) {
+	strategies := map[string][]targetWrite{
+		"0.11.2": {
+			{method: http.MethodPost, path: "/upstreams/upstream1/targets"},
+			{method: http.MethodPost, path: "/upstreams/upstream1/targets"},
+		},
+		"0.12.0": {
+			{method: http.MethodPost, path: "/upstreams/upstream1/targets/1.2.3.4:8080/unhealthy"},
+			{method: http.MethodPost, path: "/upstreams/upstream1/targets/1.2.3.4:8080/healthy"},
+		},
+		"3.4.0.0": {
+			{method: http.MethodPost, path: "/upstreams/upstream1/targets/1.2.3.4:8080/unhealthy"},
+			{method: http.MethodPost, path: "/upstreams/upstream1/targets/1.2.3.4:8080/healthy"},
+		},
+	}
+
+	for version, expected := range strategies {
+		httpServer, writes := newVersionedAdminServer(t, version)
+
+		kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, healthEndpoints: true}
+		for i, weight := range []int{0, 100} {
+			err := kclient.setTargetWeightFor("upstream1", "1.2.3.4:8080", weight)
+			require.NoError(t, err, "should not have failed to set target weight on kong %s", version)
+
+			write := <-writes
+			assert.Equal(t, expected[i].method, write.method, "kong %s", version)
+			assert.Equal(t, expected[i].path, write.path, "kong %s", version)
+		}
+
+		httpServer.Close()
+	}
+}
+
+func TestTargetsForThroughHealthEndpoints(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version": "1.5.0"}`))
+		case "/upstreams/upstream1/health":
+			w.Write([]byte(`{ "data" : [ {"target": "1.2.3.4:8080", "weight": 100, "health": "HEALTHY", "upstream": {"id": "upstream1"}}, {"target": "1.2.3.5:8080", "weight": 50, "health": "UNHEALTHY", "upstream": {"id": "upstream1"}} ] }`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, healthEndpoints: true}
+	targets, err := kclient.targetsFor("upstream1")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 2, len(targets))
+	assert.Equal(t, 100, targets[0].Weight)
+	assert.Equal(t, 0, targets[1].Weight, "should report targets kong considers unhealthy with a weight of 0")
+	assert.Equal(t, "upstream1", targets[1].UpstreamID)
+}
+
+func TestTargetsForFallsBackToWeightsWithoutHealthEndpoints(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"version": "0.11.2"}`))
+		case "/upstreams/upstream1/targets":
+			w.Write([]byte(`{ "data" : [ {"target": "1.2.3.4:8080", "weight": 100, "upstream_id": "upstream1"} ] }`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL, healthEndpoints: true}
+	targets, err := kclient.targetsFor("upstream1")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 1, len(targets))
+	assert.Equal(t, 100, targets[0].Weight)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// allWorkspaces discovers every workspace of kong enterprise
+const allWorkspaces = "*"
+
+// withWorkspaces checks the upstreams of the named workspaces of kong
+// enterprise instead of the default workspace, "*" discovers every workspace
+func withWorkspaces(workspaces []string) kongClientOption {
+	return func(kc *kongClient) {
+		for _, workspace := range workspaces {
+			if workspace == allWorkspaces {
+				kc.discoverWorkspaces = true
+				continue
+			}
+
+			kc.workspaces = append(kc.workspaces, workspace)
+		}
+	}
+}
+
+type workspaceResponse struct {
+	Data []struct {
+		Name string `json:"name"`
+	} `json:"data"`
+	Offset string `json:"offset"`
+}
+
+// listWorkspaces returns the workspaces to fetch upstreams from, the default
+// workspace is named "" and fetched without a prefix
+func (kc *kongClient) listWorkspaces() ([]string, error) {
+	if !kc.discoverWorkspaces {
+		if len(kc.workspaces) == 0 {
+			return []string{""}, nil
+		}
+
+		return kc.workspaces, nil
+	}
+
+	workspaces := []string{}
+	err := kc.paginate("workspaces", nil, func(respBytes []byte) (string, error) {
+		workspaceResponse := &workspaceResponse{}
+
+		err := json.Unmarshal(respBytes, workspaceResponse)
+		if err != nil {
+			return "", err
+		}
+
+		for _, workspace := range workspaceResponse.Data {
+			workspaces = append(workspaces, workspace.Name)
+		}
+		return workspaceResponse.Offset, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover workspaces: %s", err)
+	}
+
+	return workspaces, nil
+}
+
+// rememberWorkspaces keeps the workspace of every upstream, so that targets
+// of an upstream are fetched from and written to its workspace
+func (kc *kongClient) rememberWorkspaces(upstreams []upstream) {
+	workspaces := make(map[string]string, len(upstreams))
+	for _, u := range upstreams {
+		if u.Workspace != "" {
+			workspaces[u.ID] = u.Workspace
+		}
+	}
+
+	kc.workspaceMu.Lock()
+	defer kc.workspaceMu.Unlock()
+
+	kc.upstreamWorkspaces = workspaces
+}
+
+func (kc *kongClient) workspaceOf(upstreamID string) string {
+	kc.workspaceMu.Lock()
+	defer kc.workspaceMu.Unlock()
+
+	return kc.upstreamWorkspaces[upstreamID]
+}
+
+// upstreamPath builds the admin path of an upstream, prefixed with the
+// workspace of the upstream
+func (kc *kongClient) upstreamPath(upstreamID string, elems ...string) string {
+	path := strings.Join(append([]string{"upstreams", upstreamID}, elems...), "/")
+	return workspacePath(kc.workspaceOf(upstreamID), path)
+}
+
+func workspacePath(workspace, path string) string {
+	if workspace == "" {
+		return path
+	}
+
+	return fmt.Sprintf("%s/%s", workspace, path)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWorkspacesAdminServer fakes the admin api of kong enterprise with the
+// workspaces default and payments, writes to targets are sent on the channel
+func newWorkspacesAdminServer(t *testing.T) (*httptest.Server, chan string) {
+	writes := make(chan string, 1)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /":
+			w.Write([]byte(`{"version": "2.8.1.0-enterprise-edition"}`))
+		case "GET /workspaces":
+			w.Write([]byte(`{ "data" : [ {"name": "default"}, {"name": "payments"} ] }`))
+		case "GET /default/upstreams":
+			w.Write([]byte(`{ "data" : [ {"id": "1", "name": "orders"} ] }`))
+		case "GET /payments/upstreams":
+			w.Write([]byte(`{ "data" : [ {"id": "2", "name": "wallet"} ] }`))
+		case "GET /payments/upstreams/2/targets":
+			w.Write([]byte(`{ "data" : [ {"target": "1.2.3.4:8080", "weight": 100, "upstream": {"id": "2"}} ] }`))
+		case "GET /missing/upstreams":
+			w.WriteHeader(http.StatusNotFound)
+		case "PATCH /payments/upstreams/2/targets/1.2.3.4:8080":
+			writes <- r.URL.Path
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return httpServer, writes
+}
+
+func TestKongClientDiscoversWorkspaces(t *testing.T) {
+	httpServer, writes := newWorkspacesAdminServer(t)
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	withWorkspaces([]string{allWorkspaces})(kclient)
+
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	require.Equal(t, 2, len(upstreams))
+	assert.Equal(t, upstream{ID: "1", Name: "orders", Workspace: "default"}, upstreams[0])
+	assert.Equal(t, upstream{ID: "2", Name: "wallet", Workspace: "payments"}, upstreams[1])
+
+	targets, err := kclient.targetsFor("2")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	require.Equal(t, 1, len(targets))
+	assert.Equal(t, "payments", targets[0].Workspace)
+
+	err = kclient.setTargetWeightFor("2", "1.2.3.4:8080", 0)
+	require.NoError(t, err, "should not have failed to set target weight")
+	assert.Equal(t, "/payments/upstreams/2/targets/1.2.3.4:8080", <-writes)
+}
+
+func TestKongClientChecksListedWorkspaces(t *testing.T) {
+	httpServer, _ := newWorkspacesAdminServer(t)
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	withWorkspaces([]string{"payments"})(kclient)
+
+	upstreams, err := kclient.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	require.Equal(t, 1, len(upstreams))
+	assert.Equal(t, "payments", upstreams[0].Workspace)
+}
+
+func TestKongClientFailsOnAFailedWorkspace(t *testing.T) {
+	httpServer, _ := newWorkspacesAdminServer(t)
+	defer httpServer.Close()
+
+	kclient := &kongClient{httpClient: HTTPClient, kongAdminURL: httpServer.URL}
+	withWorkspaces([]string{"payments", "missing"})(kclient)
+
+	upstreams, err := kclient.upstreams()
+	require.Error(t, err, "should have failed to get upstreams of a missing workspace")
+	assert.Equal(t, 0, len(upstreams))
+}
+
+func TestUpstreamPath(t *testing.T) {
+	kclient := &kongClient{}
+	kclient.rememberWorkspaces([]upstream{{ID: "1"}, {ID: "2", Workspace: "payments"}})
+
+	assert.Equal(t, "upstreams/1/targets", kclient.upstreamPath("1", "targets"))
+	assert.Equal(t, "payments/upstreams/2/targets/1.2.3.4:80/healthy", kclient.upstreamPath("2", "targets", "1.2.3.4:80", "healthy"))
+}
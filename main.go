@@ -1,45 +1,323 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
-
-	"github.com/gojektech/heimdall/httpclient"
+	"time"
 )
 
-var kongHost = flag.String("kong", "", "kong host")
+var backend = flag.String("backend", "kong", "load balancer whose targets are checked, supports kong, haproxy, nginx, traefik, caddy, consul, static or dns")
+
+var kongHost = flag.String("kong", "", "comma separated kong hosts, failing over to the next host when a host fails")
 var kongAdminPort = flag.String("kong-admin-port", "8001", "kong admin port")
 var kongClientTimeout = flag.Duration("kong-client-timeout", 1000, "http client timeout")
+var kongPageSize = flag.Int("kong-page-size", 100, "no of upstreams or targets fetched per page from kong admin api")
+var useKongHealthEndpoints = flag.Bool("kong-health-endpoints", false, "mark targets through kong's healthy and unhealthy endpoints instead of changing their weight, on kong 0.12+")
+var kongAuthHeader = flag.String("kong-auth-header", "apikey", "header carrying the key read from -kong-auth-key-file")
+var kongAuthKeyFile = flag.String("kong-auth-key-file", "", "file with the key sent to kong admin api, like the key of key-auth")
+var kongBasicAuthUser = flag.String("kong-basic-auth-user", "", "user of basic credentials sent to kong admin api")
+var kongBasicAuthPasswordFile = flag.String("kong-basic-auth-password-file", "", "file with the password of basic credentials sent to kong admin api")
+var kongTLSCA = flag.String("kong-tls-ca", "", "ca bundle used to verify an https kong admin api")
+var kongTLSCert = flag.String("kong-tls-cert", "", "client certificate presented to an https kong admin api")
+var kongTLSKey = flag.String("kong-tls-key", "", "key of the client certificate presented to an https kong admin api")
+var kongWorkspaces = flag.String("kong-workspaces", "", "comma separated kong enterprise workspaces to check upstreams of, * for every workspace, the default workspace when empty")
+var kongTags = flag.String("kong-tags", "", "only check upstreams with these tags on kong 1.1+, a,b for both tags and a/b for either")
+var includeUpstreams = flag.String("include-upstreams", "", "comma separated globs, or regexes starting with ~, of the names of upstreams to check, every upstream when empty")
+var excludeUpstreams = flag.String("exclude-upstreams", "", "comma separated globs, or regexes starting with ~, of the names of upstreams not to check")
+var useKongHealthchecks = flag.Bool("kong-healthchecks", false, "check upstreams as configured by their kong active healthchecks, unless overridden in the config file")
+
+var haproxySocket = flag.String("haproxy-socket", "/var/run/haproxy.sock", "haproxy stats socket, a unix socket path or tcp://host:port")
+var haproxyTimeout = flag.Duration("haproxy-timeout", 1000*time.Millisecond, "timeout of commands sent to the haproxy stats socket")
+var haproxyMaint = flag.Bool("haproxy-maint", false, "put unhealthy servers into maintenance and make healthy servers ready instead of changing their weight")
+
+var nginxAPI = flag.String("nginx-api", "http://127.0.0.1:8080/api/8", "url of the nginx plus api, including its version")
+var nginxTimeout = flag.Duration("nginx-timeout", 1000*time.Millisecond, "timeout of requests to the nginx plus api")
+
+var traefikAPI = flag.String("traefik-api", "http://127.0.0.1:8080", "url of the traefik api")
+var traefikProvider = flag.String("traefik-provider", "file", "traefik provider whose services are checked")
+var traefikListen = flag.String("traefik-listen", ":8090", "address to serve the checked services to the traefik http provider on")
+var traefikTimeout = flag.Duration("traefik-timeout", 1000*time.Millisecond, "timeout of requests to the traefik api")
+
+var removedStateFile = flag.String("removed-state-file", "", "file to keep the servers removed from traefik or caddy in across restarts, required by the caddy backend")
+
+var caddyAdmin = flag.String("caddy-admin", "http://127.0.0.1:2019", "url of the caddy admin api")
+var caddyTimeout = flag.Duration("caddy-timeout", 1000*time.Millisecond, "timeout of requests to the caddy admin api")
+
+var consulURL = flag.String("consul", "http://127.0.0.1:8500", "url of the consul agent to read the catalog from")
+var consulAgentPort = flag.Int("consul-agent-port", 8500, "port of the consul agents on the nodes of service instances, which verdicts are reported to")
+var consulTokenFile = flag.String("consul-token-file", "", "file with the acl token sent to consul")
+var consulMaint = flag.Bool("consul-maint", false, "put unhealthy instances into maintenance instead of failing their probed:<service id> ttl check")
+var consulTimeout = flag.Duration("consul-timeout", 1000*time.Millisecond, "timeout of requests to consul")
+
+var staticFile = flag.String("static-file", "", "yaml or json file listing the upstreams and targets to check, read again when it changes")
+var staticStateFile = flag.String("static-state-file", "", "file to keep the targets of -static-file marked unhealthy in, weights are only logged when empty")
+
+var dnsUpstreams = flag.String("dns-upstreams", "", "comma separated SRV names, or host:port names of A and AAAA records, checked as upstreams of the dns backend")
+var dnsServer = flag.String("dns-server", "", "host:port of the dns server to resolve dns upstreams and tcp check targets with, the servers of the system when empty")
+
+var edsListen = flag.String("eds-listen", "", "address to serve envoy endpoint discovery on, with the targets of -backend as endpoints, weights are not written to -backend when set")
 
 var healthCheckInterval = flag.String("health-check-interval", "2000", "health check interval in ms")
 var healthCheckPath = flag.String("health-check-path", "/ping", "path to check for active health check")
-var healthCheckType = flag.String("health-check-type", "tcp", "supports http or tcp checks")
+var healthCheckType = flag.String("health-check-type", "tcp", "supports http, https, grpc or tcp checks")
+var healthCheckTimeout = flag.Duration("health-check-timeout", 0, "timeout of a single health check, the default of the check type when 0")
+var healthCheckMethod = flag.String("health-check-method", "GET", "http method of http checks")
+var healthCheckStatus = flag.String("health-check-status", "", "comma separated status codes or ranges like 200-299 accepted by http checks, any status below 500 when empty")
+var healthCheckBody = flag.String("health-check-body", "", "regex which the response body of http checks must match")
+var healthCheckHeaders = headerFlags{}
+
+var healthCheckGRPCService = flag.String("health-check-grpc-service", "", "service name sent with grpc checks, checks the whole server when empty")
+var healthCheckTLSServerName = flag.String("health-check-tls-server-name", "", "server name(SNI) used to verify targets of https checks")
+var healthCheckTLSCA = flag.String("health-check-tls-ca", "", "ca bundle used to verify targets of https checks")
+var healthCheckTLSInsecure = flag.Bool("health-check-tls-insecure", false, "skip verification of targets of https checks")
+var healthCheckTLSCert = flag.String("health-check-tls-cert", "", "client certificate presented to targets of https checks")
+var healthCheckTLSKey = flag.String("health-check-tls-key", "", "key of the client certificate presented to targets of https checks")
+
+var healthCheckRise = flag.Int("health-check-rise", 1, "no of consecutive successful checks before a target is marked healthy")
+var healthCheckFall = flag.Int("health-check-fall", 1, "no of consecutive failed checks before a target is marked unhealthy")
+
+var configFile = flag.String("config", "", "json config file with health check settings per upstream")
+
+var maxEjection = flag.String("max-ejection", "", "max no or percentage(%) of targets of an upstream which can be unhealthy at the same time")
+var slowStartDuration = flag.Duration("slow-start-duration", 0, "duration over which the weight of a recovered target is raised back, disabled when 0")
+var slowStartSteps = flag.Int("slow-start-steps", 5, "no of steps in which the weight of a recovered target is raised back")
+var stateFile = flag.String("state-file", "", "file to persist the original weights of unhealthy targets across restarts")
+var checkZeroWeight = flag.Bool("check-zero-weight-targets", false, "check kong targets with a weight of 0 which probed does not remember marking unhealthy instead of treating them as removed")
 
 var workerCount = flag.Int("worker-count", 100, "no of workers which participate in healthcheck of targets")
 var targetsQLen = flag.Int("targets-queue-length", 100, "length of the queue for storing targets")
 
+func init() {
+	flag.Var(healthCheckHeaders, "health-check-header", "header sent with http checks as \"Name: value\", can be repeated")
+}
+
+func kongClientOptions() ([]kongClientOption, error) {
+	opts := []kongClientOption{withPageSize(*kongPageSize)}
+	if *useKongHealthEndpoints {
+		opts = append(opts, withHealthEndpoints())
+	}
+
+	if *kongTags != "" {
+		opts = append(opts, withTags(*kongTags))
+	}
+
+	if *kongWorkspaces != "" {
+		workspaces := []string{}
+		for _, workspace := range strings.Split(*kongWorkspaces, ",") {
+			workspaces = append(workspaces, strings.TrimSpace(workspace))
+		}
+		opts = append(opts, withWorkspaces(workspaces))
+	}
+
+	if *kongAuthKeyFile != "" {
+		key, err := readSecretFile(*kongAuthKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, withHeader(*kongAuthHeader, key))
+	}
+
+	if *kongBasicAuthUser != "" {
+		password := ""
+		if *kongBasicAuthPasswordFile != "" {
+			var err error
+			password, err = readSecretFile(*kongBasicAuthPasswordFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, withBasicAuth(*kongBasicAuthUser, password))
+	}
+
+	if *kongTLSCA != "" || *kongTLSCert != "" || *kongTLSKey != "" {
+		tlsConfig, err := newTLSConfig(tlsOptions{caFile: *kongTLSCA, certFile: *kongTLSCert, keyFile: *kongTLSKey})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, withTLSConfig(tlsConfig))
+	}
+
+	return opts, nil
+}
+
+// newClient creates the Client of the load balancer whose targets are checked
+func newClient(backend string) (Client, error) {
+	switch backend {
+	case "kong":
+		if *kongHost == "" {
+			return nil, fmt.Errorf("`kong` flag did not provide kong host")
+		}
+
+		opts, err := kongClientOptions()
+		if err != nil {
+			return nil, err
+		}
+
+		return newKongClient(*kongHost, *kongAdminPort, *kongClientTimeout, opts...), nil
+	case "haproxy":
+		return newHAProxyClient(*haproxySocket, *haproxyTimeout, *haproxyMaint), nil
+	case "nginx":
+		return newNGINXClient(*nginxAPI, *nginxTimeout), nil
+	case "traefik":
+		traefik, err := newTraefikClient(*traefikAPI, *traefikProvider, *traefikTimeout, *removedStateFile)
+		if err != nil {
+			return nil, err
+		}
+
+		listener, err := net.Listen("tcp", *traefikListen)
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			err := http.Serve(listener, traefik)
+			if err != nil {
+				log.Fatalf("failed to serve traefik http provider: %s", err)
+			}
+		}()
+
+		return traefik, nil
+	case "caddy":
+		return newCaddyClient(*caddyAdmin, *caddyTimeout, *removedStateFile)
+	case "consul":
+		token := ""
+		if *consulTokenFile != "" {
+			var err error
+			token, err = readSecretFile(*consulTokenFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return newConsulClient(*consulURL, *consulAgentPort, token, *consulMaint, *consulTimeout)
+	case "static":
+		return newStaticClient(*staticFile, *staticStateFile)
+	case "dns":
+		if *edsListen == "" {
+			return nil, fmt.Errorf("`backend` dns can not write weights, it needs the `eds-listen` flag")
+		}
+
+		return newDNSClient(*dnsUpstreams, *dnsServer)
+	}
+
+	return nil, fmt.Errorf("unknown backend %q, supports kong, haproxy, nginx, traefik, caddy, consul, static or dns", backend)
+}
+
 func main() {
 	flag.Parse()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	if *kongHost == "" {
-		log.Fatalf("`kong` flag did not provide kong host")
+	expectation, err := newHTTPExpectation(*healthCheckMethod, http.Header(healthCheckHeaders), *healthCheckStatus, *healthCheckBody)
+	if err != nil {
+		log.Fatalf("failed to parse http check flags: %s", err)
+	}
+
+	interval, err := strconv.Atoi(*healthCheckInterval)
+	if err != nil {
+		log.Fatalf("failed to parse `health-check-interval` flag: %s", err)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("failed to load config file: %s", err)
+	}
+
+	policies, err := newCheckPolicies(cfg, checkDefaults{
+		checkType: *healthCheckType,
+		check: checkConfig{
+			path:    *healthCheckPath,
+			timeout: *healthCheckTimeout,
+			http:    expectation,
+			tls: tlsOptions{
+				serverName: *healthCheckTLSServerName,
+				caFile:     *healthCheckTLSCA,
+				certFile:   *healthCheckTLSCert,
+				keyFile:    *healthCheckTLSKey,
+				insecure:   *healthCheckTLSInsecure,
+			},
+			grpcService: *healthCheckGRPCService,
+			resolver:    newDNSResolver(*dnsServer),
+		},
+		interval: time.Duration(interval) * time.Millisecond,
+		rise:     *healthCheckRise,
+		fall:     *healthCheckFall,
+	}, *useKongHealthchecks)
+	if err != nil {
+		log.Fatalf("failed to initialise health checks: %s", err)
+	}
+
+	filter, err := newUpstreamFilter(*includeUpstreams, *excludeUpstreams)
+	if err != nil {
+		log.Fatalf("failed to parse upstream filters: %s", err)
+	}
+
+	weights, err := newWeightStore(*stateFile)
+	if err != nil {
+		log.Fatalf("failed to load state file: %s", err)
+	}
+
+	limit, err := parseEjectionLimit(*maxEjection)
+	if err != nil {
+		log.Fatalf("failed to parse `max-ejection` flag: %s", err)
+	}
+
+	var ejections *ejectionGuard
+	if limit != nil {
+		ejections = newEjectionGuard(*limit)
 	}
 
 	pingQ := make(chan target, *targetsQLen)
-	client := newKongClient(*kongHost, *kongAdminPort, *kongClientTimeout)
+	client, err := newClient(*backend)
+	if err != nil {
+		log.Fatalf("failed to configure %s client: %s", *backend, err)
+	}
+
+	if *edsListen != "" {
+		listener, err := net.Listen("tcp", *edsListen)
+		if err != nil {
+			log.Fatalf("failed to listen for envoy endpoint discovery: %s", err)
+		}
+
+		eds := newEDSClient(client)
+		client = eds
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			err := eds.serve(ctx, listener)
+			if err != nil {
+				log.Fatalf("failed to serve envoy endpoint discovery: %s", err)
+			}
+		}()
+
+		log.Printf("serving envoy endpoint discovery on %s", listener.Addr())
+	}
+
+	var ramp *slowStart
+	if *slowStartDuration > 0 {
+		ramp = newSlowStart(client, *slowStartDuration, *slowStartSteps)
+	}
 
 	p := pinger{
-		client:          client,
-		pingClient:      httpclient.NewClient(),
-		pingPath:        *healthCheckPath,
-		workQ:           pingQ,
-		healthCheckType: *healthCheckType,
+		client:     client,
+		checker:    policies.defaults.checker,
+		workQ:      pingQ,
+		thresholds: policies.defaults.thresholds,
+		weights:    weights,
+		ejections:  ejections,
+		slowStart:  ramp,
 	}
 
 	wm := newWorkerManager(*workerCount, p.start)
@@ -50,6 +328,11 @@ func main() {
 	kongHealthCheckConfig := &kongHealthCheckConfig{
 		healthCheckPath:     *healthCheckPath,
 		healthCheckInterval: *healthCheckInterval,
+		ejections:           ejections,
+		policies:            policies,
+		weights:             weights,
+		filter:              filter,
+		checkZeroWeight:     *checkZeroWeight,
 	}
 
 	healthCheck, err := newKongHealthCheck(pingQ, client, kongHealthCheckConfig)
@@ -60,7 +343,7 @@ func main() {
 	go healthCheck.start()
 	defer healthCheck.stop()
 
-	log.Printf("started kong-healthcheck for kong host: %s with interval: %s ms", *kongHost, *healthCheckInterval)
+	log.Printf("started kong-healthcheck for %s backend with interval: %s ms", *backend, *healthCheckInterval)
 	sig := <-sigChan
 	log.Printf("stopping kong-healthcheck, received os signal: %v", sig)
 }
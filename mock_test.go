@@ -20,3 +20,35 @@ func (mkc *mockClient) setTargetWeightFor(upstreamID, targetID string, weight in
 	args := mkc.Called(upstreamID, targetID, weight)
 	return args.Error(0)
 }
+
+type mockRemoverClient struct {
+	mockClient
+}
+
+func (mrc *mockRemoverClient) removeTarget(upstreamID, targetURL string) error {
+	args := mrc.Called(upstreamID, targetURL)
+	return args.Error(0)
+}
+
+func (mrc *mockRemoverClient) addTarget(upstreamID, targetURL string) error {
+	args := mrc.Called(upstreamID, targetURL)
+	return args.Error(0)
+}
+
+type mockReporterClient struct {
+	mockClient
+}
+
+func (mrc *mockReporterClient) needsVerdict(upstreamID, targetURL string) bool {
+	args := mrc.Called(upstreamID, targetURL)
+	return args.Bool(0)
+}
+
+type mockEntryWriterClient struct {
+	mockClient
+}
+
+func (mec *mockEntryWriterClient) markUnhealthyEntry(upstreamID, targetURL string) (string, error) {
+	args := mec.Called(upstreamID, targetURL)
+	return args.String(0), args.Error(1)
+}
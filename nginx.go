@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gojektech/heimdall/httpclient"
+)
+
+// nginxUpstreamKinds are the upstream zones of nginx plus, http upstreams and
+// stream upstreams of tcp and udp servers
+var nginxUpstreamKinds = []string{"http", "stream"}
+
+// nginxClient is a Client for the rest api of nginx plus. Upstreams are
+// identified as kind/name like http/orders or stream/redis, servers are
+// marked unhealthy by taking them down as nginx does not accept a weight of 0.
+type nginxClient struct {
+	httpClient httpDoer
+	apiURL     string
+	servers    *targetIDs
+}
+
+// newNGINXClient talks to the api at apiURL, which includes the version of
+// the api like http://127.0.0.1:8080/api/8
+func newNGINXClient(apiURL string, timeout time.Duration) *nginxClient {
+	if timeout == 0 {
+		timeout = 1000 * time.Millisecond
+	}
+
+	return &nginxClient{
+		httpClient: httpclient.NewClient(httpclient.WithHTTPTimeout(timeout)),
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		servers:    newTargetIDs(),
+	}
+}
+
+type nginxServer struct {
+	ID     int    `json:"id"`
+	Server string `json:"server"`
+	Weight int    `json:"weight"`
+	Down   bool   `json:"down"`
+}
+
+type nginxServerPatch struct {
+	Weight int  `json:"weight,omitempty"`
+	Down   bool `json:"down"`
+}
+
+func (nc *nginxClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+
+	for _, kind := range nginxUpstreamKinds {
+		respBytes, err := nc.doRequest(http.MethodGet, fmt.Sprintf("%s/upstreams", kind), nil)
+		if se, ok := err.(statusError); ok && se.status == http.StatusNotFound && kind == "stream" {
+			// nginx without a stream block has no stream upstreams
+			continue
+		}
+		if err != nil {
+			return []upstream{}, err
+		}
+
+		zones := map[string]json.RawMessage{}
+		err = json.Unmarshal(respBytes, &zones)
+		if err != nil {
+			return []upstream{}, err
+		}
+
+		names := make([]string, 0, len(zones))
+		for name := range zones {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			upstreams = append(upstreams, upstream{ID: fmt.Sprintf("%s/%s", kind, name), Name: name})
+		}
+	}
+
+	return upstreams, nil
+}
+
+// targetsFor lists the servers of an upstream, servers which are down are
+// reported with a weight of 0.
+func (nc *nginxClient) targetsFor(upstreamID string) ([]target, error) {
+	targets := []target{}
+
+	path, err := nginxServersPath(upstreamID)
+	if err != nil {
+		return targets, err
+	}
+
+	respBytes, err := nc.doRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return targets, err
+	}
+
+	servers := []nginxServer{}
+	err = json.Unmarshal(respBytes, &servers)
+	if err != nil {
+		return targets, err
+	}
+
+	for _, server := range servers {
+		t := target{
+			ID:         strconv.Itoa(server.ID),
+			URL:        server.Server,
+			Weight:     server.Weight,
+			UpstreamID: upstreamID,
+		}
+		if server.Down {
+			t.Weight = unhealthyNodeWeight
+		}
+
+		nc.servers.set(t, server.ID)
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// setTargetWeightFor takes the server at targetURL down for a weight of 0,
+// and brings it up with the weight otherwise.
+func (nc *nginxClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	id, err := nc.servers.lookup(upstreamID, targetURL, nc.targetsFor)
+	if err != nil {
+		return err
+	}
+
+	path, err := nginxServersPath(upstreamID)
+	if err != nil {
+		return err
+	}
+
+	patch := nginxServerPatch{Weight: weight}
+	if weight <= unhealthyNodeWeight {
+		patch = nginxServerPatch{Down: true}
+	}
+
+	requestBody, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = nc.doRequest(http.MethodPatch, fmt.Sprintf("%s/%d", path, id), requestBody)
+	return err
+}
+
+func nginxServersPath(upstreamID string) (string, error) {
+	parts := strings.SplitN(upstreamID, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid nginx upstream %q, expected kind/name", upstreamID)
+	}
+
+	return fmt.Sprintf("%s/upstreams/%s/servers", parts[0], url.PathEscape(parts[1])), nil
+}
+
+func (nc *nginxClient) doRequest(method, path string, body []byte) ([]byte, error) {
+	return doJSONRequest(nc.httpClient, method, fmt.Sprintf("%s/%s", nc.apiURL, path), body, nil)
+}
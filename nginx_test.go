@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type serverPatch struct {
+	path  string
+	patch map[string]interface{}
+}
+
+// newNGINXPlusAPI fakes the nginx plus api with an http upstream orders and a
+// stream upstream redis, patches of servers are sent on the returned channel
+func newNGINXPlusAPI(t *testing.T, withStream bool) (*httptest.Server, chan serverPatch) {
+	patches := make(chan serverPatch, 1)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/8/http/upstreams":
+			w.Write([]byte(`{"orders": {"peers": [], "zone": "orders"}, "search": {"peers": [], "zone": "search"}}`))
+		case "GET /api/8/stream/upstreams":
+			if !withStream {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error": {"status": 404, "text": "unknown stream", "code": "PathNotFound"}}`))
+				return
+			}
+			w.Write([]byte(`{"redis": {"peers": [], "zone": "redis"}}`))
+		case "GET /api/8/http/upstreams/orders/servers":
+			w.Write([]byte(`[{"id": 0, "server": "10.0.0.1:80", "weight": 5, "down": false}, {"id": 1, "server": "10.0.0.2:80", "weight": 1, "down": true}]`))
+		case "GET /api/8/stream/upstreams/redis/servers":
+			w.Write([]byte(`[{"id": 3, "server": "10.0.0.3:6379", "weight": 1}]`))
+		case "PATCH /api/8/http/upstreams/orders/servers/1", "PATCH /api/8/stream/upstreams/redis/servers/3":
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			patch := serverPatch{path: r.URL.Path}
+			require.NoError(t, json.Unmarshal(body, &patch.patch))
+			patches <- patch
+
+			w.Write(body)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return httpServer, patches
+}
+
+func TestNGINXClientListsHTTPAndStreamUpstreams(t *testing.T) {
+	httpServer, _ := newNGINXPlusAPI(t, true)
+	defer httpServer.Close()
+
+	nc := newNGINXClient(httpServer.URL+"/api/8/", Timeout)
+	upstreams, err := nc.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	assert.Equal(t, []upstream{
+		{ID: "http/orders", Name: "orders"},
+		{ID: "http/search", Name: "search"},
+		{ID: "stream/redis", Name: "redis"},
+	}, upstreams)
+}
+
+func TestNGINXClientListsUpstreamsWithoutStream(t *testing.T) {
+	httpServer, _ := newNGINXPlusAPI(t, false)
+	defer httpServer.Close()
+
+	nc := newNGINXClient(httpServer.URL+"/api/8", Timeout)
+	upstreams, err := nc.upstreams()
+	require.NoError(t, err, "should not have failed to get upstreams")
+
+	assert.Equal(t, 2, len(upstreams))
+}
+
+func TestNGINXClientListsServersAsTargets(t *testing.T) {
+	httpServer, _ := newNGINXPlusAPI(t, true)
+	defer httpServer.Close()
+
+	nc := newNGINXClient(httpServer.URL+"/api/8", Timeout)
+	targets, err := nc.targetsFor("http/orders")
+	require.NoError(t, err, "should not have failed to get targets")
+
+	assert.Equal(t, []target{
+		{ID: "0", URL: "10.0.0.1:80", Weight: 5, UpstreamID: "http/orders"},
+		{ID: "1", URL: "10.0.0.2:80", Weight: 0, UpstreamID: "http/orders"},
+	}, targets, "should report servers which are down with a weight of 0")
+
+	_, err = nc.targetsFor("orders")
+	assert.Error(t, err, "should have failed on an upstream without kind")
+}
+
+func TestNGINXClientTakesServersDownAndUp(t *testing.T) {
+	httpServer, patches := newNGINXPlusAPI(t, true)
+	defer httpServer.Close()
+
+	nc := newNGINXClient(httpServer.URL+"/api/8", Timeout)
+
+	require.NoError(t, nc.setTargetWeightFor("http/orders", "10.0.0.2:80", 0))
+	patch := <-patches
+	assert.Equal(t, "/api/8/http/upstreams/orders/servers/1", patch.path)
+	assert.Equal(t, map[string]interface{}{"down": true}, patch.patch)
+
+	require.NoError(t, nc.setTargetWeightFor("stream/redis", "10.0.0.3:6379", 3))
+	patch = <-patches
+	assert.Equal(t, "/api/8/stream/upstreams/redis/servers/3", patch.path)
+	assert.Equal(t, map[string]interface{}{"down": false, "weight": float64(3)}, patch.patch)
+
+	assert.Error(t, nc.setTargetWeightFor("http/orders", "10.0.0.9:80", 0), "should have failed on an unknown server")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var errRampStopped = errors.New("ramp stopped")
+var errRampPaused = errors.New("ramp paused")
+
+// lastStepRetries is how many times a failed last step of a ramp is retried
+// before the target is left at its partial weight.
+const lastStepRetries = 5
+
+// minStepInterval keeps ramps over short durations from spinning.
+const minStepInterval = time.Millisecond
+
+type ramp struct {
+	mu      sync.Mutex
+	stopped bool
+	paused  bool
+}
+
+// slowStart raises the weight of a recovered target back to its original
+// weight in steps spread over a duration, so that a cold target does not get
+// its whole share of traffic at once.
+type slowStart struct {
+	client   Client
+	duration time.Duration
+	steps    int
+
+	mu    sync.Mutex
+	ramps map[targetKey]*ramp
+}
+
+func newSlowStart(client Client, duration time.Duration, steps int) *slowStart {
+	if steps < 1 {
+		steps = 1
+	}
+
+	return &slowStart{
+		client:   client,
+		duration: duration,
+		steps:    steps,
+		ramps:    make(map[targetKey]*ramp),
+	}
+}
+
+// rampUp sets the first step of the weight of the target and raises it in the
+// background to weight, done is called once the target has reached it or is
+// no longer listed.
+func (ss *slowStart) rampUp(t target, weight int, done func(target)) error {
+	err := ss.client.setTargetWeightFor(t.UpstreamID, t.URL, ss.stepWeight(weight, 1))
+	if err != nil {
+		return err
+	}
+
+	if ss.steps == 1 {
+		done(t)
+		return nil
+	}
+
+	r := &ramp{}
+
+	ss.mu.Lock()
+	if previous, ok := ss.ramps[keyFor(t)]; ok {
+		previous.stop()
+	}
+	ss.ramps[keyFor(t)] = r
+	ss.mu.Unlock()
+
+	go ss.run(t, weight, r, done)
+	return nil
+}
+
+// stop stops the ramp of the target if there is one in progress and reports
+// whether there was.
+func (ss *slowStart) stop(t target) bool {
+	if ss == nil {
+		return false
+	}
+
+	ss.mu.Lock()
+	r, ok := ss.ramps[keyFor(t)]
+	delete(ss.ramps, keyFor(t))
+	ss.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	r.stop()
+	return true
+}
+
+// pause holds the ramp of the target at its current step while the target
+// fails checks, and reports whether a ramp in progress was paused by the call.
+func (ss *slowStart) pause(t target) bool {
+	return ss.setPaused(t, true)
+}
+
+// resume continues the paused ramp of the target once it passes checks again,
+// and reports whether a paused ramp was resumed by the call.
+func (ss *slowStart) resume(t target) bool {
+	return ss.setPaused(t, false)
+}
+
+func (ss *slowStart) setPaused(t target, paused bool) bool {
+	if ss == nil {
+		return false
+	}
+
+	ss.mu.Lock()
+	r, ok := ss.ramps[keyFor(t)]
+	ss.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := r.paused != paused
+	r.paused = paused
+	return changed
+}
+
+// run raises the weight a step every interval. A paused ramp stays at its
+// step. The last step is retried so that the target does not stay at a
+// partial weight, until the target is no longer listed or lastStepRetries
+// retries failed.
+func (ss *slowStart) run(t target, weight int, r *ramp, done func(target)) {
+	interval := ss.duration / time.Duration(ss.steps)
+	if interval < minStepInterval {
+		interval = minStepInterval
+	}
+
+	retries := 0
+	for step := 2; step <= ss.steps; {
+		time.Sleep(interval)
+
+		stepWeight := ss.stepWeight(weight, step)
+		err := r.do(func() error { return ss.client.setTargetWeightFor(t.UpstreamID, t.URL, stepWeight) })
+		if err == errRampStopped {
+			log.Printf("stopped slow start of target %s", t.URL)
+			return
+		}
+
+		if err == errRampPaused {
+			continue
+		}
+
+		if err != nil && step == ss.steps {
+			if !ss.isListed(t) {
+				log.Printf("target %s is no longer listed, stopping its slow start", t.URL)
+				break
+			}
+
+			if retries == lastStepRetries {
+				log.Printf("failed to restore weight of target %s at the end of slow start, giving up: reason: %s", t.URL, err)
+				ss.forgetRamp(t, r)
+				return
+			}
+
+			retries++
+			log.Printf("failed to restore weight of target %s at the end of slow start, retrying: reason: %s", t.URL, err)
+			continue
+		}
+
+		if err != nil {
+			log.Printf("failed to raise weight of target %s during slow start: reason: %s", t.URL, err)
+		}
+
+		step++
+	}
+
+	ss.forgetRamp(t, r)
+	done(t)
+}
+
+// isListed reports whether the target is still listed by the client, a
+// target is assumed to be listed while its targets can not be fetched.
+func (ss *slowStart) isListed(t target) bool {
+	targets, err := ss.client.targetsFor(t.UpstreamID)
+	if err != nil {
+		return true
+	}
+
+	for _, listed := range targets {
+		if listed.URL == t.URL {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ss *slowStart) forgetRamp(t target, r *ramp) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.ramps[keyFor(t)] == r {
+		delete(ss.ramps, keyFor(t))
+	}
+}
+
+func (ss *slowStart) stepWeight(weight, step int) int {
+	stepWeight := weight * step / ss.steps
+	if stepWeight < 1 {
+		return 1
+	}
+
+	return stepWeight
+}
+
+// do runs a step of the ramp unless it has been stopped or paused. Holding
+// the lock while the step runs makes sure no step lands after the ramp is
+// stopped.
+func (r *ramp) do(step func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return errRampStopped
+	}
+
+	if r.paused {
+		return errRampPaused
+	}
+
+	return step()
+}
+
+func (r *ramp) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+}
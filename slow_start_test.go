@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rShetty/asyncwait"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func isPaused(ss *slowStart, t target) bool {
+	ss.mu.Lock()
+	r, ok := ss.ramps[keyFor(t)]
+	ss.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.paused
+}
+
+func TestSlowStartRaisesWeightInSteps(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 15).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 30).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 45).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 60).Return(nil).Once()
+
+	done := make(chan target)
+	ss := newSlowStart(mockClient, 20*time.Millisecond, 4)
+	err := ss.rampUp(tgt, 60, func(t target) { done <- t })
+	require.NoError(t, err, "should not have failed to start the ramp")
+
+	select {
+	case restored := <-done:
+		assert.Equal(t, tgt, restored)
+	case <-time.After(time.Second):
+		t.Fatal("ramp never completed")
+	}
+
+	assert.False(t, ss.stop(tgt), "should not have a ramp in progress")
+	mockClient.AssertExpectations(t)
+}
+
+func TestSlowStartStopsWhenTargetFailsAgain(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 50).Return(nil).Once()
+
+	ss := newSlowStart(mockClient, time.Hour, 2)
+	err := ss.rampUp(tgt, 100, func(target) { t.Error("ramp should not have completed") })
+	require.NoError(t, err, "should not have failed to start the ramp")
+
+	assert.True(t, ss.stop(tgt), "should have stopped the ramp in progress")
+	assert.False(t, ss.stop(tgt))
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestSlowStartFailsWhenFirstStepFails(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 1).Return(errors.New("failed"))
+
+	ss := newSlowStart(mockClient, time.Hour, 5)
+	err := ss.rampUp(tgt, 3, func(target) { t.Error("ramp should not have completed") })
+	require.Error(t, err, "should have failed to start the ramp")
+
+	assert.False(t, ss.stop(tgt), "should not have a ramp in progress")
+	mockClient.AssertExpectations(t)
+}
+
+func TestSlowStartRetriesLastStepUntilItSucceeds(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 5).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 10).Return(errors.New("failed")).Twice()
+	mockClient.On("targetsFor", "upstream1").Return([]target{tgt}, nil).Twice()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 10).Return(nil).Once()
+
+	done := make(chan target)
+	ss := newSlowStart(mockClient, 10*time.Millisecond, 2)
+	err := ss.rampUp(tgt, 10, func(t target) { done <- t })
+	require.NoError(t, err, "should not have failed to start the ramp")
+
+	select {
+	case restored := <-done:
+		assert.Equal(t, tgt, restored)
+	case <-time.After(time.Second):
+		t.Fatal("ramp never completed")
+	}
+
+	assert.False(t, ss.stop(tgt), "should not have a ramp in progress")
+	mockClient.AssertExpectations(t)
+}
+
+func TestSlowStartStopsRetryingLastStepOfUnlistedTarget(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 5).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 10).Return(errors.New("failed")).Once()
+	mockClient.On("targetsFor", "upstream1").Return([]target{{URL: "1.2.3.5:80", Weight: 100, UpstreamID: "upstream1"}}, nil).Once()
+
+	done := make(chan target)
+	ss := newSlowStart(mockClient, 10*time.Millisecond, 2)
+	err := ss.rampUp(tgt, 10, func(t target) { done <- t })
+	require.NoError(t, err, "should not have failed to start the ramp")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ramp never ended")
+	}
+
+	assert.False(t, ss.stop(tgt), "should not have a ramp in progress")
+	mockClient.AssertExpectations(t)
+}
+
+func TestSlowStartGivesUpLastStepAfterRetries(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 5).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 10).Return(errors.New("failed")).Times(lastStepRetries + 1)
+	mockClient.On("targetsFor", "upstream1").Return([]target{tgt}, nil).Times(lastStepRetries + 1)
+
+	ss := newSlowStart(mockClient, 0, 2)
+	err := ss.rampUp(tgt, 10, func(target) { t.Error("ramp should not have completed") })
+	require.NoError(t, err, "should not have failed to start the ramp")
+
+	predicate := func() bool {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+
+		return len(ss.ramps) == 0
+	}
+	require.True(t, asyncwait.NewAsyncWait(500, 5).Check(predicate), "should have given up the ramp")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestSlowStartPausesWhileTargetFails(t *testing.T) {
+	mockClient := new(mockClient)
+	tgt := target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 25).Return(nil).Once()
+
+	done := make(chan target)
+	ss := newSlowStart(mockClient, 20*time.Millisecond, 4)
+	err := ss.rampUp(tgt, 100, func(t target) { done <- t })
+	require.NoError(t, err, "should not have failed to start the ramp")
+
+	assert.True(t, ss.pause(tgt), "should have paused the ramp in progress")
+	assert.True(t, isPaused(ss, tgt))
+	assert.False(t, ss.pause(tgt), "should already be paused")
+
+	select {
+	case <-done:
+		t.Fatal("paused ramp should not have completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mockClient.AssertExpectations(t)
+
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 50).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 75).Return(nil).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", "1.2.3.4:80", 100).Return(nil).Once()
+	assert.True(t, ss.resume(tgt), "should have resumed the ramp")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ramp never completed")
+	}
+
+	assert.False(t, ss.resume(tgt), "should not have a ramp in progress")
+	mockClient.AssertExpectations(t)
+}
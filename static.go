@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// staticTargets is the file of a staticClient, json for a file with a .json
+// extension and yaml otherwise
+type staticTargets struct {
+	Upstreams []staticUpstream `json:"upstreams" yaml:"upstreams"`
+}
+
+type staticUpstream struct {
+	Name    string         `json:"name" yaml:"name"`
+	Targets []staticTarget `json:"targets" yaml:"targets"`
+}
+
+// staticTarget is a target of a static upstream, its weight defaults to
+// healthyNodeWeight
+type staticTarget struct {
+	URL    string `json:"target" yaml:"target"`
+	Weight *int   `json:"weight" yaml:"weight"`
+}
+
+// staticClient is a Client for upstreams and targets listed in a file, for
+// services which are not behind a load balancer. The file is read again
+// whenever it changes on disk. Weights set by probed are only logged, the
+// targets probed marked unhealthy are kept in a state file when statePath is
+// set, the file itself is never written to.
+type staticClient struct {
+	path      string
+	statePath string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	listed  []staticUpstream
+	weights map[targetKey]int
+}
+
+func newStaticClient(path, statePath string) (*staticClient, error) {
+	sc := &staticClient{
+		path:      path,
+		statePath: statePath,
+		weights:   make(map[targetKey]int),
+	}
+
+	if statePath != "" {
+		storedWeights, err := readStoredWeights(statePath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sw := range storedWeights {
+			sc.weights[targetKey{upstreamID: sw.UpstreamID, url: sw.URL}] = sw.Weight
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	err := sc.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return sc, nil
+}
+
+func (sc *staticClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	err := sc.reload()
+	if err != nil {
+		return upstreams, err
+	}
+
+	for _, u := range sc.listed {
+		upstreams = append(upstreams, upstream{ID: u.Name, Name: u.Name})
+	}
+
+	return upstreams, nil
+}
+
+// targetsFor lists the targets of an upstream in the file, with a weight of 0
+// in place of the weight in the file for targets probed marked unhealthy
+func (sc *staticClient) targetsFor(upstreamID string) ([]target, error) {
+	targets := []target{}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	err := sc.reload()
+	if err != nil {
+		return targets, err
+	}
+
+	for _, u := range sc.listed {
+		if u.Name != upstreamID {
+			continue
+		}
+
+		for _, st := range u.Targets {
+			weight := healthyNodeWeight
+			if st.Weight != nil {
+				weight = *st.Weight
+			}
+
+			if setWeight, ok := sc.weights[targetKey{upstreamID: upstreamID, url: st.URL}]; ok {
+				weight = setWeight
+			}
+
+			targets = append(targets, target{URL: st.URL, Weight: weight, UpstreamID: upstreamID})
+		}
+
+		return targets, nil
+	}
+
+	return targets, fmt.Errorf("unknown upstream %s in %s", upstreamID, sc.path)
+}
+
+func (sc *staticClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	key := targetKey{upstreamID: upstreamID, url: targetURL}
+	if weight == unhealthyNodeWeight {
+		sc.weights[key] = weight
+	} else {
+		delete(sc.weights, key)
+	}
+	log.Printf("set weight of target %s of upstream %s to %d", targetURL, upstreamID, weight)
+
+	return sc.persist()
+}
+
+// reload reads the file again when its modification time or size changed
+// since it was last read. Once the file has been read, a change which fails
+// to parse is logged and the upstreams read before are served until the file
+// changes again.
+func (sc *staticClient) reload() error {
+	info, err := os.Stat(sc.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(sc.modTime) && info.Size() == sc.size {
+		return nil
+	}
+
+	fileBytes, err := ioutil.ReadFile(sc.path)
+	if err != nil {
+		return err
+	}
+
+	targets := staticTargets{}
+	if filepath.Ext(sc.path) == ".json" {
+		err = json.Unmarshal(fileBytes, &targets)
+	} else {
+		err = yaml.UnmarshalStrict(fileBytes, &targets)
+	}
+	if err != nil && sc.modTime.IsZero() {
+		return fmt.Errorf("failed to parse %s: %s", sc.path, err)
+	}
+
+	sc.modTime = info.ModTime()
+	sc.size = info.Size()
+	if err != nil {
+		log.Printf("failed to parse %s, serving the upstreams read before: reason: %s", sc.path, err)
+		return nil
+	}
+
+	sc.listed = targets.Upstreams
+	sc.forgetRemovedTargets()
+
+	return nil
+}
+
+// forgetRemovedTargets drops the weights of targets which are no longer in
+// the file
+func (sc *staticClient) forgetRemovedTargets() {
+	inFile := make(map[targetKey]bool)
+	for _, u := range sc.listed {
+		for _, st := range u.Targets {
+			inFile[targetKey{upstreamID: u.Name, url: st.URL}] = true
+		}
+	}
+
+	for key := range sc.weights {
+		if !inFile[key] {
+			delete(sc.weights, key)
+		}
+	}
+}
+
+func (sc *staticClient) persist() error {
+	if sc.statePath == "" {
+		return nil
+	}
+
+	storedWeights := make([]storedWeight, 0, len(sc.weights))
+	for key, weight := range sc.weights {
+		storedWeights = append(storedWeights, storedWeight{UpstreamID: key.upstreamID, URL: key.url, Weight: weight})
+	}
+
+	return writeStoredWeights(sc.statePath, storedWeights)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeStaticFile(t *testing.T, path, content string, modTime time.Time) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestStaticClientReadsYAMLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.yaml")
+	writeStaticFile(t, path, `
+upstreams:
+- name: orders
+  targets:
+  - target: 10.0.0.1:8080
+  - target: 10.0.0.2:8080
+    weight: 50
+- name: search
+  targets:
+  - target: 10.0.0.3:9200
+    weight: 0
+`, time.Now())
+
+	sc, err := newStaticClient(path, "")
+	require.NoError(t, err)
+
+	upstreams, err := sc.upstreams()
+	require.NoError(t, err)
+	assert.Equal(t, []upstream{{ID: "orders", Name: "orders"}, {ID: "search", Name: "search"}}, upstreams)
+
+	targets, err := sc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "orders"},
+		{URL: "10.0.0.2:8080", Weight: 50, UpstreamID: "orders"},
+	}, targets)
+
+	targets, err = sc.targetsFor("search")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{URL: "10.0.0.3:9200", Weight: 0, UpstreamID: "search"}}, targets)
+
+	_, err = sc.targetsFor("payments")
+	assert.Error(t, err)
+}
+
+func TestStaticClientReadsJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.json")
+	writeStaticFile(t, path, `{"upstreams": [{"name": "orders", "targets": [{"target": "10.0.0.1:8080", "weight": 20}]}]}`, time.Now())
+
+	sc, err := newStaticClient(path, "")
+	require.NoError(t, err)
+
+	targets, err := sc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{URL: "10.0.0.1:8080", Weight: 20, UpstreamID: "orders"}}, targets)
+}
+
+func TestStaticClientFailsOnInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = newStaticClient(filepath.Join(dir, "missing.yaml"), "")
+	assert.Error(t, err)
+
+	path := filepath.Join(dir, "targets.yaml")
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  servers: []\n", time.Now())
+
+	_, err = newStaticClient(path, "")
+	assert.Error(t, err, "should not accept unknown fields")
+}
+
+func TestStaticClientReadsFileAgainWhenItChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.yaml")
+	modTime := time.Now().Add(-time.Minute)
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  targets:\n  - target: 10.0.0.1:8080\n  - target: 10.0.0.2:8080\n", modTime)
+
+	sc, err := newStaticClient(path, "")
+	require.NoError(t, err)
+	require.NoError(t, sc.setTargetWeightFor("orders", "10.0.0.2:8080", 0))
+
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  targets:\n  - target: 10.0.0.1:8080\n", modTime.Add(time.Second))
+
+	targets, err := sc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "orders"}}, targets)
+
+	writeStaticFile(t, path, "upstreams: [", modTime.Add(2*time.Second))
+
+	targets, err = sc.targetsFor("orders")
+	require.NoError(t, err, "should have served the file read before")
+	assert.Equal(t, []target{{URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "orders"}}, targets)
+
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  targets:\n  - target: 10.0.0.1:8080\n  - target: 10.0.0.2:8080\n", modTime.Add(3*time.Second))
+
+	targets, err = sc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 100, targets[1].Weight, "should have forgotten the weight of the removed target")
+}
+
+func TestStaticClientKeepsWeightsInStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.yaml")
+	statePath := filepath.Join(dir, "state.json")
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  targets:\n  - target: 10.0.0.1:8080\n    weight: 30\n", time.Now())
+
+	sc, err := newStaticClient(path, statePath)
+	require.NoError(t, err)
+	require.NoError(t, sc.setTargetWeightFor("orders", "10.0.0.1:8080", 0))
+
+	fileBytes, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(fileBytes), "weight: 30", "should not have written to the file")
+
+	restarted, err := newStaticClient(path, statePath)
+	require.NoError(t, err)
+
+	targets, err := restarted.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{URL: "10.0.0.1:8080", Weight: 0, UpstreamID: "orders"}}, targets)
+}
+
+func TestStaticClientServesWeightsOfFileOnceTargetsRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.yaml")
+	statePath := filepath.Join(dir, "state.json")
+	modTime := time.Now().Add(-time.Minute)
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  targets:\n  - target: 10.0.0.1:8080\n    weight: 30\n", modTime)
+
+	sc, err := newStaticClient(path, statePath)
+	require.NoError(t, err)
+	require.NoError(t, sc.setTargetWeightFor("orders", "10.0.0.1:8080", 0))
+	require.NoError(t, sc.setTargetWeightFor("orders", "10.0.0.1:8080", 30))
+
+	writeStaticFile(t, path, "upstreams:\n- name: orders\n  targets:\n  - target: 10.0.0.1:8080\n    weight: 60\n", modTime.Add(time.Second))
+
+	targets, err := sc.targetsFor("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{URL: "10.0.0.1:8080", Weight: 60, UpstreamID: "orders"}}, targets, "should serve the changed weight of the file")
+
+	storedWeights, err := readStoredWeights(statePath)
+	require.NoError(t, err)
+	assert.Empty(t, storedWeights, "should have dropped the verdict of the recovered target")
+}
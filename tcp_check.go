@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func init() {
+	registerChecker("tcp", func(cfg checkConfig) (Checker, error) {
+		return tcpChecker{timeout: cfg.timeout, resolver: cfg.resolver}, nil
+	})
+}
+
+// tcpChecker checks whether a target accepts tcp connections. A target with
+// a host name is only healthy when every address of the name accepts
+// connections, addresses are dialed at the same time. Addresses of a network
+// probed has no route to, like ipv6 addresses on an ipv4 only host, are
+// skipped as long as another address could be dialed.
+type tcpChecker struct {
+	timeout  time.Duration
+	resolver *net.Resolver
+}
+
+func (tc tcpChecker) check(t target) error {
+	host, port, err := net.SplitHostPort(t.URL)
+	if err != nil {
+		return err
+	}
+
+	if net.ParseIP(host) != nil {
+		return tc.dial(t.URL)
+	}
+
+	lookupTimeout := dnsLookupTimeout
+	if tc.timeout > 0 {
+		lookupTimeout = tc.timeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	addrs, err := lookupAddrs(ctx, tc.resolver, host)
+	if err != nil {
+		return err
+	}
+
+	results := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			results <- tc.dial(net.JoinHostPort(addr, port))
+		}(addr)
+	}
+
+	failures, unreachable := []string{}, 0
+	for range addrs {
+		err := <-results
+		if err == nil {
+			continue
+		}
+
+		if errors.Is(err, syscall.ENETUNREACH) {
+			unreachable++
+			continue
+		}
+
+		failures = append(failures, err.Error())
+	}
+
+	if unreachable == len(addrs) {
+		return fmt.Errorf("no address of %s is reachable", t.URL)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d addresses of %s refused connections: %s", len(failures), len(addrs), t.URL, strings.Join(failures, ", "))
+	}
+
+	return nil
+}
+
+func (tc tcpChecker) dial(addr string) error {
+	dialer := net.Dialer{Timeout: tc.timeout}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPChecker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := listener.Addr().String()
+	assert.NoError(t, tcpChecker{}.check(target{URL: addr}), "should be healthy when accepting connections")
+
+	listener.Close()
+	assert.Error(t, tcpChecker{}.check(target{URL: addr}), "should be unhealthy when refusing connections")
+	assert.Error(t, tcpChecker{}.check(target{URL: "localhost"}), "should be unhealthy without a port")
+}
+
+func TestTCPCheckerDialsIPv6Addresses(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6 loopback")
+	}
+	defer listener.Close()
+
+	assert.NoError(t, tcpChecker{}.check(target{URL: listener.Addr().String()}))
+}
+
+func TestTCPCheckerDialsEveryAddressOfName(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	addr, stop := startDNSServer(t,
+		"orders.probed.test. 60 IN A 127.0.0.1",
+		"orders.probed.test. 60 IN A 127.0.0.2",
+	)
+	defer stop()
+
+	tc := tcpChecker{timeout: time.Second, resolver: newDNSResolver(addr)}
+	assert.Error(t, tc.check(target{URL: net.JoinHostPort("orders.probed.test", port)}), "should be unhealthy when one of the addresses refuses connections")
+
+	secondListener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	require.NoError(t, err)
+	defer secondListener.Close()
+
+	assert.NoError(t, tc.check(target{URL: net.JoinHostPort("orders.probed.test", port)}), "should be healthy when every address accepts connections")
+}
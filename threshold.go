@@ -0,0 +1,73 @@
+package main
+
+import "sync"
+
+// targetKey identifies a target of an upstream across health check ticks
+type targetKey struct {
+	upstreamID string
+	url        string
+}
+
+func keyFor(t target) targetKey {
+	return targetKey{upstreamID: t.UpstreamID, url: t.URL}
+}
+
+type thresholdCounter struct {
+	successes int
+	failures  int
+}
+
+// thresholdTracker counts consecutive check results of every target, so that
+// the weight of a target is only changed after rise successful or fall failed
+// checks in a row.
+type thresholdTracker struct {
+	rise int
+	fall int
+
+	mu       sync.Mutex
+	counters map[targetKey]*thresholdCounter
+}
+
+func newThresholdTracker(rise, fall int) *thresholdTracker {
+	if rise < 1 {
+		rise = 1
+	}
+	if fall < 1 {
+		fall = 1
+	}
+
+	return &thresholdTracker{
+		rise:     rise,
+		fall:     fall,
+		counters: make(map[targetKey]*thresholdCounter),
+	}
+}
+
+// record registers the result of a check and reports whether the target has
+// agreed with it for enough consecutive checks to act upon it. A nil tracker
+// acts on every result.
+func (tt *thresholdTracker) record(t target, healthy bool) bool {
+	if tt == nil {
+		return true
+	}
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	key := keyFor(t)
+	counter, ok := tt.counters[key]
+	if !ok {
+		counter = &thresholdCounter{}
+		tt.counters[key] = counter
+	}
+
+	if healthy {
+		counter.successes++
+		counter.failures = 0
+		return counter.successes >= tt.rise
+	}
+
+	counter.failures++
+	counter.successes = 0
+	return counter.failures >= tt.fall
+}
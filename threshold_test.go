@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdTrackerActsAfterConsecutiveFailures(t *testing.T) {
+	tracker := newThresholdTracker(2, 3)
+	tgt := target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}
+
+	assert.False(t, tracker.record(tgt, false))
+	assert.False(t, tracker.record(tgt, false))
+	assert.True(t, tracker.record(tgt, false))
+	assert.True(t, tracker.record(tgt, false))
+}
+
+func TestThresholdTrackerActsAfterConsecutiveSuccesses(t *testing.T) {
+	tracker := newThresholdTracker(2, 3)
+	tgt := target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}
+
+	assert.False(t, tracker.record(tgt, true))
+	assert.True(t, tracker.record(tgt, true))
+}
+
+func TestThresholdTrackerResetsOnDisagreeingResult(t *testing.T) {
+	tracker := newThresholdTracker(2, 2)
+	tgt := target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}
+
+	assert.False(t, tracker.record(tgt, false))
+	assert.False(t, tracker.record(tgt, true))
+	assert.False(t, tracker.record(tgt, false))
+	assert.True(t, tracker.record(tgt, false))
+}
+
+func TestThresholdTrackerTracksTargetsPerUpstream(t *testing.T) {
+	tracker := newThresholdTracker(1, 2)
+
+	assert.False(t, tracker.record(target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}, false))
+	assert.False(t, tracker.record(target{URL: "1.2.3.4:80", UpstreamID: "upstream2"}, false))
+	assert.True(t, tracker.record(target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}, false))
+}
+
+func TestThresholdTrackerDefaultsToActingOnEveryResult(t *testing.T) {
+	var nilTracker *thresholdTracker
+	tgt := target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}
+
+	assert.True(t, nilTracker.record(tgt, false))
+	assert.True(t, newThresholdTracker(0, 0).record(tgt, false))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsOptions configures how probed verifies a tls server and which
+// certificate it presents to it
+type tlsOptions struct {
+	serverName string
+	caFile     string
+	certFile   string
+	keyFile    string
+	insecure   bool
+}
+
+func newTLSConfig(opts tlsOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         opts.serverName,
+		InsecureSkipVerify: opts.insecure,
+	}
+
+	if opts.caFile != "" {
+		caBytes, err := ioutil.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in ca bundle %s", opts.caFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.certFile != "" || opts.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "probed"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "probed.crt")
+	keyFile := filepath.Join(dir, "probed.key")
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	tlsConfig, err := newTLSConfig(tlsOptions{serverName: "api.example.com", caFile: certFile, certFile: certFile, keyFile: keyFile})
+	require.NoError(t, err, "should not have failed to create tls config")
+
+	assert.Equal(t, "api.example.com", tlsConfig.ServerName)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Equal(t, 1, len(tlsConfig.Certificates))
+}
+
+func TestNewTLSConfigDefaults(t *testing.T) {
+	tlsConfig, err := newTLSConfig(tlsOptions{insecure: true})
+	require.NoError(t, err, "should not have failed to create tls config")
+
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Empty(t, tlsConfig.Certificates)
+}
+
+func TestNewTLSConfigFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, _ := writeTestCertificate(t, dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	require.NoError(t, ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	_, err = newTLSConfig(tlsOptions{caFile: filepath.Join(dir, "missing.crt")})
+	assert.Error(t, err, "should have failed on missing ca bundle")
+
+	_, err = newTLSConfig(tlsOptions{caFile: notPEM})
+	assert.Error(t, err, "should have failed on ca bundle without certificates")
+
+	_, err = newTLSConfig(tlsOptions{certFile: certFile})
+	assert.Error(t, err, "should have failed on client certificate without key")
+}
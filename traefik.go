@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gojektech/heimdall/httpclient"
+)
+
+// traefikClient is a Client for traefik, which reads the services of a
+// provider like file from the api of traefik, and serves them back to the http
+// provider of traefik without the servers probed removed. Routers use the
+// services of the http provider, like orders@http, to route around unhealthy
+// servers as traefik does not support a weight of 0.
+type traefikClient struct {
+	httpClient httpDoer
+	apiURL     string
+	provider   string
+	removed    *removedTargets
+
+	mu       sync.Mutex
+	services map[string]*traefikService
+	synced   bool
+}
+
+// traefikService is a load balancer service, its config other than the
+// servers is served as it is
+type traefikService struct {
+	Name         string                 `json:"name"`
+	Provider     string                 `json:"provider"`
+	LoadBalancer map[string]interface{} `json:"loadBalancer"`
+	servers      []map[string]interface{}
+}
+
+// newTraefikClient keeps the servers it removed in the state file at
+// statePath when it is set, so that they stay removed across restarts.
+func newTraefikClient(apiURL, provider string, timeout time.Duration, statePath string) (*traefikClient, error) {
+	if timeout == 0 {
+		timeout = 1000 * time.Millisecond
+	}
+
+	removed, err := newRemovedTargets(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &traefikClient{
+		httpClient: httpclient.NewClient(httpclient.WithHTTPTimeout(timeout)),
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		provider:   provider,
+		removed:    removed,
+		services:   make(map[string]*traefikService),
+	}, nil
+}
+
+// upstreams lists the load balancer services of the provider, following the
+// pages of the api of traefik
+func (tc *traefikClient) upstreams() ([]upstream, error) {
+	upstreams := []upstream{}
+	services := make(map[string]*traefikService)
+
+	for page := "1"; page != ""; {
+		response, err := tc.get(fmt.Sprintf("api/http/services?page=%s", page))
+		if err != nil {
+			return []upstream{}, err
+		}
+
+		pageServices := []*traefikService{}
+		err = json.NewDecoder(response.Body).Decode(&pageServices)
+		response.Body.Close()
+		if err != nil {
+			return []upstream{}, err
+		}
+
+		for _, service := range pageServices {
+			if service.Provider != tc.provider || service.LoadBalancer == nil {
+				continue
+			}
+
+			service.servers = traefikServers(service.LoadBalancer["servers"])
+			services[service.Name] = service
+			upstreams = append(upstreams, upstream{ID: service.Name, Name: strings.TrimSuffix(service.Name, "@"+tc.provider)})
+		}
+
+		page = response.Header.Get("X-Next-Page")
+		if page == "1" {
+			page = ""
+		}
+	}
+
+	tc.mu.Lock()
+	tc.services = services
+	tc.synced = true
+	tc.mu.Unlock()
+
+	return upstreams, nil
+}
+
+// targetsFor lists the servers of a service, the servers probed removed are
+// still configured in the provider and are reported with a weight of 0.
+func (tc *traefikClient) targetsFor(upstreamID string) ([]target, error) {
+	targets := []target{}
+
+	tc.mu.Lock()
+	service, ok := tc.services[upstreamID]
+	tc.mu.Unlock()
+	if !ok {
+		return targets, fmt.Errorf("unknown traefik service %s", upstreamID)
+	}
+
+	for _, server := range service.servers {
+		hostPort, err := traefikServerHostPort(server)
+		if err != nil {
+			return []target{}, err
+		}
+
+		weight := healthyNodeWeight
+		if tc.removed.isRemoved(upstreamID, hostPort) {
+			weight = unhealthyNodeWeight
+		}
+
+		targets = append(targets, target{URL: hostPort, Weight: weight, UpstreamID: upstreamID})
+	}
+
+	return targets, nil
+}
+
+// setTargetWeightFor removes the server at targetURL for a weight of 0 and
+// re-adds it otherwise, traefik does not support weights of servers.
+func (tc *traefikClient) setTargetWeightFor(upstreamID, targetURL string, weight int) error {
+	if weight <= unhealthyNodeWeight {
+		return tc.removeTarget(upstreamID, targetURL)
+	}
+
+	return tc.addTarget(upstreamID, targetURL)
+}
+
+func (tc *traefikClient) removeTarget(upstreamID, targetURL string) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	service, ok := tc.services[upstreamID]
+	if !ok {
+		return fmt.Errorf("unknown traefik service %s", upstreamID)
+	}
+
+	for _, server := range service.servers {
+		hostPort, err := traefikServerHostPort(server)
+		if err == nil && hostPort == targetURL {
+			return tc.removed.remove(upstreamID, targetURL, server)
+		}
+	}
+
+	return fmt.Errorf("no server at %s in traefik service %s", targetURL, upstreamID)
+}
+
+func (tc *traefikClient) addTarget(upstreamID, targetURL string) error {
+	if !tc.removed.isRemoved(upstreamID, targetURL) {
+		return fmt.Errorf("server at %s was not removed from traefik service %s", targetURL, upstreamID)
+	}
+
+	return tc.removed.forget(upstreamID, targetURL)
+}
+
+// ServeHTTP serves the dynamic configuration of the http provider of
+// traefik, with the services of the provider without their removed servers.
+// Until the services have been read, traefik keeps its last configuration.
+func (tc *traefikClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if !tc.synced {
+		http.Error(w, "services have not been read from traefik yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	services := make(map[string]interface{}, len(tc.services))
+	for name, service := range tc.services {
+		servers := []map[string]interface{}{}
+		for _, server := range service.servers {
+			hostPort, err := traefikServerHostPort(server)
+			if err == nil && tc.removed.isRemoved(name, hostPort) {
+				continue
+			}
+
+			servers = append(servers, server)
+		}
+
+		loadBalancer := make(map[string]interface{}, len(service.LoadBalancer))
+		for key, value := range service.LoadBalancer {
+			loadBalancer[key] = value
+		}
+		loadBalancer["servers"] = servers
+
+		services[strings.TrimSuffix(name, "@"+tc.provider)] = map[string]interface{}{"loadBalancer": loadBalancer}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"http": map[string]interface{}{"services": services}})
+}
+
+func (tc *traefikClient) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", tc.apiURL, path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %s", err)
+	}
+
+	response, err := tc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		return nil, statusError{status: response.StatusCode}
+	}
+
+	return response, nil
+}
+
+func traefikServers(servers interface{}) []map[string]interface{} {
+	list, _ := servers.([]interface{})
+
+	configs := []map[string]interface{}{}
+	for _, server := range list {
+		if config, ok := server.(map[string]interface{}); ok {
+			configs = append(configs, config)
+		}
+	}
+
+	return configs
+}
+
+// traefikServerHostPort is the host:port of the url of a server, with the
+// default port of its scheme when the url has none
+func traefikServerHostPort(server map[string]interface{}) (string, error) {
+	rawURL, _ := server["url"].(string)
+
+	serverURL, err := url.Parse(rawURL)
+	if err != nil || serverURL.Host == "" {
+		return "", fmt.Errorf("invalid url of traefik server %q", rawURL)
+	}
+
+	if serverURL.Port() != "" {
+		return serverURL.Host, nil
+	}
+
+	port := "80"
+	if serverURL.Scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(serverURL.Hostname(), port), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTraefikAPI fakes the api of traefik with two pages of services, orders
+// and search of the file provider and dashboard of the internal provider
+func newTraefikAPI(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/http/services" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.URL.Query().Get("page") {
+		case "1":
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"name": "dashboard@internal", "provider": "internal"}, {"name": "orders@file", "provider": "file", "loadBalancer": {"passHostHeader": true, "servers": [{"url": "http://10.0.0.1:8080"}, {"url": "http://10.0.0.2"}]}}]`))
+		case "2":
+			w.Header().Set("X-Next-Page", "1")
+			w.Write([]byte(`[{"name": "search@file", "provider": "file", "loadBalancer": {"servers": [{"url": "https://10.0.0.3"}]}}, {"name": "mirror@file", "provider": "file", "mirroring": {"service": "orders@file"}}]`))
+		default:
+			t.Errorf("unexpected page %s", r.URL.Query().Get("page"))
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func servedTraefikConfig(t *testing.T, tc *traefikClient) map[string]interface{} {
+	recorder := httptest.NewRecorder()
+	tc.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	config := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &config))
+
+	return config
+}
+
+func TestTraefikClientListsLoadBalancerServicesOfProvider(t *testing.T) {
+	httpServer := newTraefikAPI(t)
+	defer httpServer.Close()
+
+	tc, err := newTraefikClient(httpServer.URL, "file", 0, "")
+	require.NoError(t, err)
+
+	upstreams, err := tc.upstreams()
+	require.NoError(t, err)
+	assert.Equal(t, []upstream{{ID: "orders@file", Name: "orders"}, {ID: "search@file", Name: "search"}}, upstreams)
+}
+
+func TestTraefikClientListsServersAsTargets(t *testing.T) {
+	httpServer := newTraefikAPI(t)
+	defer httpServer.Close()
+
+	tc, err := newTraefikClient(httpServer.URL, "file", 0, "")
+	require.NoError(t, err)
+	_, err = tc.upstreams()
+	require.NoError(t, err)
+
+	targets, err := tc.targetsFor("orders@file")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{URL: "10.0.0.1:8080", Weight: 100, UpstreamID: "orders@file"},
+		{URL: "10.0.0.2:80", Weight: 100, UpstreamID: "orders@file"},
+	}, targets)
+
+	targets, err = tc.targetsFor("search@file")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{URL: "10.0.0.3:443", Weight: 100, UpstreamID: "search@file"}}, targets)
+
+	_, err = tc.targetsFor("payments@file")
+	assert.Error(t, err)
+}
+
+func TestTraefikClientServesServicesWithoutRemovedServers(t *testing.T) {
+	httpServer := newTraefikAPI(t)
+	defer httpServer.Close()
+
+	tc, err := newTraefikClient(httpServer.URL, "file", 0, "")
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	tc.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code, "should not serve services before reading them")
+
+	_, err = tc.upstreams()
+	require.NoError(t, err)
+
+	require.NoError(t, tc.setTargetWeightFor("orders@file", "10.0.0.2:80", 0))
+
+	targets, err := tc.targetsFor("orders@file")
+	require.NoError(t, err)
+	assert.Equal(t, 0, targets[1].Weight, "should report the removed server with a weight of 0")
+
+	orders := map[string]interface{}{"loadBalancer": map[string]interface{}{
+		"passHostHeader": true,
+		"servers":        []interface{}{map[string]interface{}{"url": "http://10.0.0.1:8080"}},
+	}}
+	assert.Equal(t, orders, servedTraefikConfig(t, tc)["http"].(map[string]interface{})["services"].(map[string]interface{})["orders"])
+
+	require.NoError(t, tc.setTargetWeightFor("orders@file", "10.0.0.2:80", 100))
+
+	servers := servedTraefikConfig(t, tc)["http"].(map[string]interface{})["services"].(map[string]interface{})["orders"].(map[string]interface{})["loadBalancer"].(map[string]interface{})["servers"]
+	assert.Len(t, servers, 2, "should serve the re-added server")
+
+	assert.Error(t, tc.setTargetWeightFor("orders@file", "10.0.0.9:80", 0), "should not remove unknown servers")
+	assert.Error(t, tc.setTargetWeightFor("orders@file", "10.0.0.1:8080", 100), "should not add servers which were not removed")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+)
+
+// upstreamFilter decides which upstreams probed checks, so that upstreams
+// managed by other teams or by kong's own health checks are left alone
+type upstreamFilter struct {
+	include []func(string) bool
+	exclude []func(string) bool
+}
+
+// newUpstreamFilter parses comma separated patterns of upstream names to
+// include and exclude, a pattern starting with ~ is a regex and any other
+// pattern a glob. Every upstream is included when include is empty.
+func newUpstreamFilter(include, exclude string) (*upstreamFilter, error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+
+	includes, err := parseUpstreamPatterns(include)
+	if err != nil {
+		return nil, err
+	}
+
+	excludes, err := parseUpstreamPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upstreamFilter{include: includes, exclude: excludes}, nil
+}
+
+func parseUpstreamPatterns(patterns string) ([]func(string) bool, error) {
+	matchers := []func(string) bool{}
+	if patterns == "" {
+		return matchers, nil
+	}
+
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+
+		uc := upstreamCheckConfig{Glob: pattern}
+		if strings.HasPrefix(pattern, "~") {
+			uc = upstreamCheckConfig{Regex: strings.TrimPrefix(pattern, "~")}
+		}
+
+		matches, err := upstreamMatcher(uc)
+		if err != nil {
+			return nil, err
+		}
+
+		matchers = append(matchers, matches)
+	}
+
+	return matchers, nil
+}
+
+func (uf *upstreamFilter) allows(u upstream) bool {
+	if uf == nil {
+		return true
+	}
+
+	for _, matches := range uf.exclude {
+		if matches(u.Name) {
+			return false
+		}
+	}
+
+	if len(uf.include) == 0 {
+		return true
+	}
+
+	for _, matches := range uf.include {
+		if matches(u.Name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamFilterIncludesAndExcludesByGlobAndRegex(t *testing.T) {
+	filter, err := newUpstreamFilter("orders-*, ~^payments-(a|b)$", "orders-legacy")
+	require.NoError(t, err, "should not have failed to parse filters")
+
+	allowed := map[string]bool{
+		"orders-api":    true,
+		"orders-legacy": false,
+		"payments-a":    true,
+		"payments-c":    false,
+		"redis":         false,
+	}
+
+	for name, expected := range allowed {
+		assert.Equal(t, expected, filter.allows(upstream{Name: name}), name)
+	}
+}
+
+func TestUpstreamFilterOnlyExcludes(t *testing.T) {
+	filter, err := newUpstreamFilter("", "~^team-b-")
+	require.NoError(t, err, "should not have failed to parse filters")
+
+	assert.True(t, filter.allows(upstream{Name: "orders"}))
+	assert.False(t, filter.allows(upstream{Name: "team-b-search"}))
+}
+
+func TestUpstreamFilterAllowsEveryUpstreamWhenEmpty(t *testing.T) {
+	filter, err := newUpstreamFilter("", "")
+	require.NoError(t, err, "should not have failed to parse filters")
+
+	assert.Nil(t, filter)
+	assert.True(t, filter.allows(upstream{Name: "orders"}))
+}
+
+func TestUpstreamFilterFailsOnInvalidPatterns(t *testing.T) {
+	_, err := newUpstreamFilter("orders-[", "")
+	assert.Error(t, err, "should have failed on an invalid glob")
+
+	_, err = newUpstreamFilter("", "~orders-(")
+	assert.Error(t, err, "should have failed on an invalid regex")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type storedWeight struct {
+	UpstreamID string `json:"upstream_id"`
+	URL        string `json:"target"`
+	Weight     int    `json:"weight"`
+	EntryID    string `json:"entry_id,omitempty"`
+}
+
+// weightStore remembers the weight a target had before it was marked
+// unhealthy, so that the same weight is restored once it recovers, and the
+// entry which marked it unhealthy on load balancers which keep a history of
+// targets. When backed by a state file they survive restarts of probed.
+type weightStore struct {
+	path string
+
+	mu      sync.Mutex
+	weights map[targetKey]int
+	entries map[targetKey]string
+}
+
+func newWeightStore(path string) (*weightStore, error) {
+	ws := &weightStore{
+		path:    path,
+		weights: make(map[targetKey]int),
+		entries: make(map[targetKey]string),
+	}
+
+	if path == "" {
+		return ws, nil
+	}
+
+	storedWeights, err := readStoredWeights(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sw := range storedWeights {
+		key := targetKey{upstreamID: sw.UpstreamID, url: sw.URL}
+		ws.weights[key] = sw.Weight
+		if sw.EntryID != "" {
+			ws.entries[key] = sw.EntryID
+		}
+	}
+
+	return ws, nil
+}
+
+// remember stores the current weight of a target which is about to be marked
+// unhealthy. A weight which is already stored is kept, as the target might be
+// failing part way through its slow start.
+func (ws *weightStore) remember(t target) error {
+	if ws == nil || t.Weight <= 0 {
+		return nil
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	key := keyFor(t)
+	if _, ok := ws.weights[key]; ok {
+		return nil
+	}
+
+	ws.weights[key] = t.Weight
+	return ws.persist()
+}
+
+// rememberEntry stores the id of the entry which marked a target unhealthy,
+// for a target whose weight is stored.
+func (ws *weightStore) rememberEntry(t target, entryID string) error {
+	if ws == nil || entryID == "" {
+		return nil
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	key := keyFor(t)
+	if _, ok := ws.weights[key]; !ok {
+		return nil
+	}
+
+	ws.entries[key] = entryID
+	return ws.persist()
+}
+
+// entryFor returns the id of the entry which marked a target unhealthy,
+// reporting whether it is known.
+func (ws *weightStore) entryFor(t target) (string, bool) {
+	if ws == nil {
+		return "", false
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	entryID, ok := ws.entries[keyFor(t)]
+	return entryID, ok
+}
+
+// weightFor returns the weight to restore for a target, falling back to
+// healthyNodeWeight when its original weight is not known.
+func (ws *weightStore) weightFor(t target) int {
+	if ws == nil {
+		return healthyNodeWeight
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	weight, ok := ws.weights[keyFor(t)]
+	if !ok {
+		return healthyNodeWeight
+	}
+
+	return weight
+}
+
+// remembers reports whether a weight is stored for the target, which means
+// probed marked it unhealthy.
+func (ws *weightStore) remembers(t target) bool {
+	if ws == nil {
+		return false
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	_, ok := ws.weights[keyFor(t)]
+	return ok
+}
+
+// forget removes the stored weight of a target once it has been restored.
+func (ws *weightStore) forget(t target) error {
+	if ws == nil {
+		return nil
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	key := keyFor(t)
+	if _, ok := ws.weights[key]; !ok {
+		return nil
+	}
+
+	delete(ws.weights, key)
+	delete(ws.entries, key)
+	return ws.persist()
+}
+
+func (ws *weightStore) persist() error {
+	if ws.path == "" {
+		return nil
+	}
+
+	storedWeights := make([]storedWeight, 0, len(ws.weights))
+	for key, weight := range ws.weights {
+		storedWeights = append(storedWeights, storedWeight{UpstreamID: key.upstreamID, URL: key.url, Weight: weight, EntryID: ws.entries[key]})
+	}
+
+	return writeStoredWeights(ws.path, storedWeights)
+}
+
+// readStoredWeights reads the weights of a state file, a state file which does
+// not exist yet has no weights
+func readStoredWeights(path string) ([]storedWeight, error) {
+	storedWeights := []storedWeight{}
+
+	err := readStateFile(path, &storedWeights)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedWeights, nil
+}
+
+// writeStoredWeights replaces a state file with the weights
+func writeStoredWeights(path string, storedWeights []storedWeight) error {
+	return writeStateFile(path, storedWeights)
+}
+
+// readStateFile decodes the json of a state file into state, leaving state as
+// it is when the file does not exist yet
+func readStateFile(path string, state interface{}) error {
+	stateBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(stateBytes, state)
+}
+
+// writeStateFile replaces a state file with the json of state through a
+// rename, so that a crash never leaves a partly written file
+func writeStateFile(path string, state interface{}) error {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(stateBytes)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightStoreRemembersWeightsOfTargets(t *testing.T) {
+	ws, err := newWeightStore("")
+	require.NoError(t, err, "should not have failed to create weight store")
+
+	tgt := target{URL: "1.2.3.4:80", Weight: 30, UpstreamID: "upstream1"}
+
+	require.NoError(t, ws.remember(tgt))
+	assert.Equal(t, 30, ws.weightFor(target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}))
+	assert.Equal(t, healthyNodeWeight, ws.weightFor(target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream2"}))
+
+	require.NoError(t, ws.forget(tgt))
+	assert.Equal(t, healthyNodeWeight, ws.weightFor(tgt))
+}
+
+func TestWeightStoreKeepsAlreadyStoredWeight(t *testing.T) {
+	ws, err := newWeightStore("")
+	require.NoError(t, err, "should not have failed to create weight store")
+
+	require.NoError(t, ws.remember(target{URL: "1.2.3.4:80", Weight: 60, UpstreamID: "upstream1"}))
+	require.NoError(t, ws.remember(target{URL: "1.2.3.4:80", Weight: 12, UpstreamID: "upstream1"}))
+	assert.Equal(t, 60, ws.weightFor(target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}))
+}
+
+func TestWeightStoreDoesNotRememberZeroWeights(t *testing.T) {
+	ws, err := newWeightStore("")
+	require.NoError(t, err, "should not have failed to create weight store")
+
+	require.NoError(t, ws.remember(target{URL: "1.2.3.4:80", Weight: 0, UpstreamID: "upstream1"}))
+	assert.Equal(t, healthyNodeWeight, ws.weightFor(target{URL: "1.2.3.4:80", UpstreamID: "upstream1"}))
+}
+
+func TestWeightStoreSurvivesRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	tgt := target{URL: "1.2.3.4:80", Weight: 60, UpstreamID: "upstream1"}
+
+	ws, err := newWeightStore(stateFile)
+	require.NoError(t, err, "should not have failed to create weight store")
+	require.NoError(t, ws.remember(tgt))
+
+	restarted, err := newWeightStore(stateFile)
+	require.NoError(t, err, "should not have failed to load state file")
+	assert.Equal(t, 60, restarted.weightFor(tgt))
+
+	require.NoError(t, restarted.rememberEntry(tgt, "entry1"))
+
+	restarted, err = newWeightStore(stateFile)
+	require.NoError(t, err, "should not have failed to load state file")
+	entryID, ok := restarted.entryFor(tgt)
+	require.True(t, ok, "should have kept the entry across restarts")
+	assert.Equal(t, "entry1", entryID)
+
+	require.NoError(t, restarted.forget(tgt))
+
+	restarted, err = newWeightStore(stateFile)
+	require.NoError(t, err, "should not have failed to load state file")
+	assert.Equal(t, healthyNodeWeight, restarted.weightFor(tgt))
+	_, ok = restarted.entryFor(tgt)
+	assert.False(t, ok, "should have forgotten the entry with the weight")
+}
+
+func TestWeightStoreFailsOnCorruptStateFile(t *testing.T) {
+	stateFile, err := ioutil.TempFile("", "probed")
+	require.NoError(t, err)
+	defer os.Remove(stateFile.Name())
+
+	stateFile.WriteString(`[{"upstream_id": "upstream1", "target": `)
+	stateFile.Close()
+
+	_, err = newWeightStore(stateFile.Name())
+	require.Error(t, err, "should have failed to load corrupt state file")
+}
+
+func TestWeightStoreDefaultsToHealthyNodeWeight(t *testing.T) {
+	var nilStore *weightStore
+	tgt := target{URL: "1.2.3.4:80", Weight: 60, UpstreamID: "upstream1"}
+
+	assert.NoError(t, nilStore.remember(tgt))
+	assert.Equal(t, healthyNodeWeight, nilStore.weightFor(tgt))
+	assert.NoError(t, nilStore.forget(tgt))
+}
+
+func TestWeightStoreRemembersTargetsMarkedUnhealthy(t *testing.T) {
+	ws, err := newWeightStore("")
+	require.NoError(t, err)
+
+	healthy := target{URL: "1.2.3.4:80", Weight: 100, UpstreamID: "1"}
+	assert.False(t, ws.remembers(healthy))
+
+	require.NoError(t, ws.remember(healthy))
+	assert.True(t, ws.remembers(healthy))
+
+	require.NoError(t, ws.forget(healthy))
+	assert.False(t, ws.remembers(healthy))
+
+	var nilStore *weightStore
+	assert.False(t, nilStore.remembers(healthy))
+}
@@ -1,24 +1,18 @@
 package main
 
-import (
-	"errors"
-	"fmt"
-	"log"
-	"net"
-	"net/http"
-
-	"github.com/gojektech/heimdall/httpclient"
-)
+import "log"
 
 const unhealthyNodeWeight = 0
 const healthyNodeWeight = 100
 
 type pinger struct {
-	client          Client
-	pingClient      *httpclient.Client
-	pingPath        string
-	workQ           chan target
-	healthCheckType string
+	client     Client
+	checker    Checker
+	workQ      chan target
+	thresholds *thresholdTracker
+	weights    *weightStore
+	ejections  *ejectionGuard
+	slowStart  *slowStart
 }
 
 func (p pinger) start() {
@@ -26,18 +20,45 @@ func (p pinger) start() {
 		log.Printf("pinging target %s", t.URL)
 		currentWeight := t.Weight
 
-		var err error
-		if p.healthCheckType == "http" {
-			err = p.httpPingCheck(t)
-		} else if p.healthCheckType == "tcp" {
-			err = p.tcpPortCheck(t)
+		checker, thresholds := p.checker, p.thresholds
+		if t.policy != nil {
+			checker, thresholds = t.policy.checker, t.policy.thresholds
+		}
+
+		err := checker.check(t)
+
+		if err != nil && p.slowStart.pause(t) {
+			log.Printf("target %s is down during slow start, pausing it", t.URL)
+		}
+		if err == nil && p.slowStart.resume(t) {
+			log.Printf("target %s is up again, resuming its slow start", t.URL)
+		}
+
+		if !thresholds.record(t, err == nil) {
+			continue
 		}
 
 		if err != nil && currentWeight > 0 {
+			if p.slowStart.stop(t) {
+				log.Printf("target %s is down during slow start", t.URL)
+			}
+
+			err := p.ejections.reserve(t)
+			if err != nil {
+				log.Printf("target %s is down, not marking it as unhealthy: reason: %s", t.URL, err)
+				continue
+			}
+
 			log.Printf("target %s is down, marking it as unhealthy", t.URL)
-			err := p.client.setTargetWeightFor(t.UpstreamID, t.URL, unhealthyNodeWeight)
+			err = p.weights.remember(t)
+			if err != nil {
+				log.Printf("failed to store weight of target %s: reason: %s", t.URL, err)
+			}
+
+			err = p.markUnhealthy(t)
 			if err != nil {
 				log.Printf("failed to mark target %s as unhealthy: reason: %s", t.URL, err)
+				p.ejections.release(t)
 				continue
 			}
 
@@ -47,48 +68,84 @@ func (p pinger) start() {
 		// Previously marked unhealthy node is healthy
 		if currentWeight <= 0 && err == nil {
 			log.Printf("target %s is up, marking it as healthy", t.URL)
-			err := p.client.setTargetWeightFor(t.UpstreamID, t.URL, healthyNodeWeight)
+			err := p.markHealthy(t)
 			if err != nil {
 				log.Printf("failed to mark target %s as healthy: reason: %s", t.URL, err)
 				continue
 			}
 
+			p.ejections.release(t)
 			continue
 		}
+
+		if err == nil && p.needsVerdict(t) {
+			log.Printf("target %s is up, reporting it as healthy", t.URL)
+			err := p.client.setTargetWeightFor(t.UpstreamID, t.URL, currentWeight)
+			if err != nil {
+				log.Printf("failed to report target %s as healthy: reason: %s", t.URL, err)
+			}
+		}
 	}
 }
 
-func (p pinger) tcpPortCheck(t target) error {
-	tcpAddr, err := net.ResolveTCPAddr("tcp4", t.URL)
-	if err != nil {
-		return err
+func (p pinger) needsVerdict(t target) bool {
+	reporter, ok := p.client.(verdictReporter)
+	return ok && reporter.needsVerdict(t.UpstreamID, t.URL)
+}
+
+func (p pinger) markUnhealthy(t target) error {
+	if remover, ok := p.client.(targetRemover); ok {
+		return remover.removeTarget(t.UpstreamID, t.URL)
 	}
 
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
-	if err != nil {
-		return err
+	if writer, ok := p.client.(entryWriter); ok {
+		entryID, err := writer.markUnhealthyEntry(t.UpstreamID, t.URL)
+		if err != nil {
+			return err
+		}
+
+		err = p.weights.rememberEntry(t, entryID)
+		if err != nil {
+			log.Printf("failed to store the entry which marked target %s as unhealthy: reason: %s", t.URL, err)
+		}
+
+		return nil
 	}
 
-	defer conn.Close()
-	return nil
+	return p.client.setTargetWeightFor(t.UpstreamID, t.URL, unhealthyNodeWeight)
 }
 
-func (p pinger) httpPingCheck(t target) error {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s", t.URL, p.pingPath), nil)
-	if err != nil {
-		return err
+// markHealthy restores the weight of a target, or re-adds it to load
+// balancers which do not support a weight of 0.
+func (p pinger) markHealthy(t target) error {
+	if remover, ok := p.client.(targetRemover); ok {
+		err := remover.addTarget(t.UpstreamID, t.URL)
+		if err != nil {
+			return err
+		}
+
+		p.weightRestored(t)
+		return nil
+	}
+
+	weight := p.weights.weightFor(t)
+
+	if p.slowStart != nil {
+		return p.slowStart.rampUp(t, weight, p.weightRestored)
 	}
 
-	response, err := p.pingClient.Do(req)
+	err := p.client.setTargetWeightFor(t.UpstreamID, t.URL, weight)
 	if err != nil {
 		return err
 	}
 
-	defer response.Body.Close()
+	p.weightRestored(t)
+	return nil
+}
 
-	if response.StatusCode >= http.StatusInternalServerError {
-		return errors.New("sever not available")
+func (p pinger) weightRestored(t target) {
+	err := p.weights.forget(t)
+	if err != nil {
+		log.Printf("failed to clear stored weight of target %s: reason: %s", t.URL, err)
 	}
-
-	return nil
 }
@@ -5,10 +5,13 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rShetty/asyncwait"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -41,7 +44,7 @@ func TestPingCheckHTTPMarksUnhealthyNodes(t *testing.T) {
 
 	mockClient.On("setTargetWeightFor", "upstream3", svr3.URL, 0).Return(nil)
 
-	p := pinger{client: mockClient, pingClient: HTTPClient, pingPath: *healthCheckPath, workQ: pingQ, healthCheckType: "http"}
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ}
 	go p.start()
 
 	predicate := func() bool { return len(pingQ) == 0 }
@@ -80,7 +83,7 @@ func TestPingCheckHTTPMarksHealthyNodes(t *testing.T) {
 
 	mockClient.On("setTargetWeightFor", "upstream1", svr1.URL, 100).Return(nil)
 
-	p := pinger{client: mockClient, pingClient: HTTPClient, pingPath: *healthCheckPath, workQ: pingQ, healthCheckType: "http"}
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ}
 	go p.start()
 
 	predicate := func() bool { return len(pingQ) == 0 }
@@ -120,7 +123,7 @@ func TestPingCheckHTTPMarksHealthyNodesFails(t *testing.T) {
 	mockClient.On("setTargetWeightFor", "upstream1", svr1.URL, 100).Return(nil)
 	mockClient.On("setTargetWeightFor", "upstream2", svr2.URL, 100).Return(errors.New("failed"))
 
-	p := pinger{client: mockClient, pingClient: HTTPClient, pingPath: *healthCheckPath, workQ: pingQ, healthCheckType: "http"}
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ}
 	go p.start()
 
 	predicate := func() bool { return len(pingQ) == 0 }
@@ -157,7 +160,7 @@ func TestPingCheckHTTPNotMarksNodeAsUnhealthyIfAlreadyUnhealthy(t *testing.T) {
 	pingQ <- target{URL: svr2.URL, Weight: 100, UpstreamID: "upstream2"}
 	pingQ <- target{URL: svr3.URL, Weight: 0, UpstreamID: "upstream3"}
 
-	p := pinger{client: mockClient, pingClient: HTTPClient, pingPath: *healthCheckPath, workQ: pingQ, healthCheckType: "http"}
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ}
 	go p.start()
 
 	predicate := func() bool { return len(pingQ) == 0 }
@@ -190,11 +193,9 @@ func TestTCPPortCheckMarksNodesHealthyOrUnhealthy(t *testing.T) {
 	mockClient.On("setTargetWeightFor", "upstream4", "localhost:4000", 0).Return(nil)
 
 	p := pinger{
-		client:          mockClient,
-		pingClient:      HTTPClient,
-		pingPath:        *healthCheckPath,
-		workQ:           pingQ,
-		healthCheckType: "tcp",
+		client:  mockClient,
+		checker: tcpChecker{},
+		workQ:   pingQ,
 	}
 	go p.start()
 
@@ -206,3 +207,405 @@ func TestTCPPortCheckMarksNodesHealthyOrUnhealthy(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestPingCheckHTTPWaitsForFallThresholdBeforeMarkingUnhealthy(t *testing.T) {
+	mockClient := new(mockClient)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer svr.Close()
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 100, UpstreamID: "upstream1"}
+	pingQ <- target{URL: svr.URL, Weight: 100, UpstreamID: "upstream1"}
+
+	marked := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 0).Return(nil).Once().Run(func(mock.Arguments) {
+		assert.Equal(t, 0, len(pingQ), "should have marked the target only after the second failure")
+		close(marked)
+	})
+
+	p := pinger{
+		client:     mockClient,
+		checker:    httpChecker{client: HTTPClient, path: *healthCheckPath},
+		workQ:      pingQ,
+		thresholds: newThresholdTracker(1, 2),
+	}
+	go p.start()
+
+	select {
+	case <-marked:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked unhealthy")
+	}
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPRestoresOriginalWeightOfRecoveredNode(t *testing.T) {
+	mockClient := new(mockClient)
+
+	var healthy int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	weights, err := newWeightStore("")
+	require.NoError(t, err)
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 30, UpstreamID: "upstream1"}
+
+	markedDown := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 0).Return(nil).Once().Run(func(mock.Arguments) {
+		close(markedDown)
+	})
+
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ, weights: weights}
+	go p.start()
+
+	select {
+	case <-markedDown:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked unhealthy")
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	markedUp := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 30).Return(nil).Once().Run(func(mock.Arguments) {
+		close(markedUp)
+	})
+	pingQ <- target{URL: svr.URL, Weight: 0, UpstreamID: "upstream1"}
+
+	select {
+	case <-markedUp:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked healthy")
+	}
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPRemovesAndReAddsTargetsOfRemoverClients(t *testing.T) {
+	mockClient := new(mockRemoverClient)
+
+	var healthy int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	slowStart := newSlowStart(mockClient, time.Minute, 4)
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 100, UpstreamID: "upstream1"}
+
+	removed := make(chan bool)
+	mockClient.On("removeTarget", "upstream1", svr.URL).Return(nil).Once().Run(func(mock.Arguments) {
+		close(removed)
+	})
+
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ, slowStart: slowStart}
+	go p.start()
+
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("target was never removed")
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	added := make(chan bool)
+	mockClient.On("addTarget", "upstream1", svr.URL).Return(nil).Once().Run(func(mock.Arguments) {
+		close(added)
+	})
+	pingQ <- target{URL: svr.URL, Weight: 0, UpstreamID: "upstream1"}
+
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("target was never re-added")
+	}
+
+	mockClient.AssertNotCalled(t, "setTargetWeightFor", mock.Anything, mock.Anything, mock.Anything)
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPRemembersEntryWhichMarkedTargetUnhealthy(t *testing.T) {
+	mockClient := new(mockEntryWriterClient)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer svr.Close()
+
+	weights, err := newWeightStore("")
+	require.NoError(t, err)
+
+	pingQ := make(chan target, 1)
+	pingQ <- target{URL: svr.URL, Weight: 50, UpstreamID: "upstream1"}
+	close(pingQ)
+
+	mockClient.On("markUnhealthyEntry", "upstream1", svr.URL).Return("entry1", nil).Once()
+
+	pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ, weights: weights}.start()
+
+	entryID, ok := weights.entryFor(target{URL: svr.URL, UpstreamID: "upstream1"})
+	require.True(t, ok, "should have remembered the entry which marked the target unhealthy")
+	assert.Equal(t, "entry1", entryID)
+	assert.Equal(t, 50, weights.weightFor(target{URL: svr.URL, UpstreamID: "upstream1"}))
+	mockClient.AssertNotCalled(t, "setTargetWeightFor", mock.Anything, mock.Anything, mock.Anything)
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPReportsHealthyTargetsWhichNeedAVerdict(t *testing.T) {
+	mockClient := new(mockReporterClient)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	pingQ := make(chan target, 2)
+	pingQ <- target{URL: svr.URL, Weight: 100, UpstreamID: "upstream1"}
+	pingQ <- target{URL: svr.URL, Weight: 100, UpstreamID: "upstream1"}
+	close(pingQ)
+
+	mockClient.On("needsVerdict", "upstream1", svr.URL).Return(true).Once()
+	mockClient.On("needsVerdict", "upstream1", svr.URL).Return(false).Once()
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 100).Return(nil).Once()
+
+	pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ}.start()
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPNotMarksNodesUnhealthyPastEjectionLimit(t *testing.T) {
+	mockClient := new(mockClient)
+
+	svr1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer svr1.Close()
+	svr2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer svr2.Close()
+
+	targets := []target{
+		{URL: svr1.URL, Weight: 100, UpstreamID: "upstream1"},
+		{URL: svr2.URL, Weight: 100, UpstreamID: "upstream1"},
+	}
+
+	ejections := newEjectionGuard(ejectionLimit{value: 50, percent: true})
+	ejections.observe("upstream1", targets)
+
+	pingQ := make(chan target, 10)
+	pingQ <- targets[0]
+	pingQ <- targets[1]
+
+	marked := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr1.URL, 0).Return(nil).Once().Run(func(mock.Arguments) {
+		close(marked)
+	})
+
+	p := pinger{client: mockClient, checker: httpChecker{client: HTTPClient, path: *healthCheckPath}, workQ: pingQ, ejections: ejections}
+	go p.start()
+
+	select {
+	case <-marked:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked unhealthy")
+	}
+
+	predicate := func() bool { return len(pingQ) == 0 }
+	successful := asyncwait.NewAsyncWait(100, 5).Check(predicate)
+	require.True(t, successful)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPSlowStartsRecoveredNode(t *testing.T) {
+	mockClient := new(mockClient)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 0, UpstreamID: "upstream1"}
+
+	firstStep := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 20).Return(nil).Once().Run(func(mock.Arguments) {
+		close(firstStep)
+	})
+
+	p := pinger{
+		client:    mockClient,
+		checker:   httpChecker{client: HTTPClient, path: *healthCheckPath},
+		workQ:     pingQ,
+		slowStart: newSlowStart(mockClient, time.Hour, 5),
+	}
+	go p.start()
+
+	select {
+	case <-firstStep:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked healthy")
+	}
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPPausesSlowStartOfFailingNodeBelowFallThreshold(t *testing.T) {
+	mockClient := new(mockClient)
+
+	var healthy int32 = 1
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 0, UpstreamID: "upstream1"}
+
+	firstStep := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 20).Return(nil).Once().Run(func(mock.Arguments) {
+		close(firstStep)
+	})
+
+	ramp := newSlowStart(mockClient, time.Hour, 5)
+	p := pinger{
+		client:     mockClient,
+		checker:    httpChecker{client: HTTPClient, path: *healthCheckPath},
+		workQ:      pingQ,
+		thresholds: newThresholdTracker(1, 3),
+		slowStart:  ramp,
+	}
+	go p.start()
+
+	select {
+	case <-firstStep:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked healthy")
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	pingQ <- target{URL: svr.URL, Weight: 20, UpstreamID: "upstream1"}
+
+	predicate := func() bool { return isPaused(ramp, target{URL: svr.URL, UpstreamID: "upstream1"}) }
+	require.True(t, asyncwait.NewAsyncWait(100, 5).Check(predicate), "should have paused the ramp")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckHTTPStopsSlowStartOfFailingNodeWhenEjectionIsRefused(t *testing.T) {
+	mockClient := new(mockClient)
+
+	var healthy int32 = 1
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	ejections := newEjectionGuard(ejectionLimit{value: 0})
+	ejections.observe("upstream1", []target{{URL: svr.URL, Weight: 0, UpstreamID: "upstream1"}})
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 0, UpstreamID: "upstream1"}
+
+	firstStep := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 20).Return(nil).Once().Run(func(mock.Arguments) {
+		close(firstStep)
+	})
+
+	ramp := newSlowStart(mockClient, time.Hour, 5)
+	p := pinger{
+		client:     mockClient,
+		checker:    httpChecker{client: HTTPClient, path: *healthCheckPath},
+		workQ:      pingQ,
+		thresholds: newThresholdTracker(1, 2),
+		ejections:  ejections,
+		slowStart:  ramp,
+	}
+	go p.start()
+
+	select {
+	case <-firstStep:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked healthy")
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	ejections.observe("upstream1", []target{{URL: svr.URL, Weight: 20, UpstreamID: "upstream1"}})
+	pingQ <- target{URL: svr.URL, Weight: 20, UpstreamID: "upstream1"}
+	pingQ <- target{URL: svr.URL, Weight: 20, UpstreamID: "upstream1"}
+
+	predicate := func() bool { return !ramp.stop(target{URL: svr.URL, UpstreamID: "upstream1"}) }
+	require.True(t, asyncwait.NewAsyncWait(100, 5).Check(predicate), "should have stopped the ramp")
+
+	mockClient.AssertNotCalled(t, "setTargetWeightFor", "upstream1", svr.URL, 0)
+	mockClient.AssertExpectations(t)
+}
+
+func TestPingCheckUsesPolicyOfTarget(t *testing.T) {
+	mockClient := new(mockClient)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer svr.Close()
+
+	policy := &checkPolicy{
+		checker:    httpChecker{client: HTTPClient, path: "/health"},
+		thresholds: newThresholdTracker(1, 1),
+	}
+
+	pingQ := make(chan target, 10)
+	pingQ <- target{URL: svr.URL, Weight: 100, UpstreamID: "upstream1", policy: policy}
+
+	marked := make(chan bool)
+	mockClient.On("setTargetWeightFor", "upstream1", svr.URL, 0).Return(nil).Once().Run(func(mock.Arguments) {
+		close(marked)
+	})
+
+	p := pinger{
+		client:     mockClient,
+		checker:    tcpChecker{},
+		workQ:      pingQ,
+		thresholds: newThresholdTracker(1, 5),
+	}
+	go p.start()
+
+	select {
+	case <-marked:
+	case <-time.After(time.Second):
+		t.Fatal("target was never marked unhealthy")
+	}
+
+	mockClient.AssertExpectations(t)
+}